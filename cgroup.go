@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errCgroupsUnsupported = errors.New("cgroup v2 isn't available")
+
+// resourceUsage is what's known about a process's cgroup.
+type resourceUsage struct {
+	memory    uint64
+	hasMemory bool
+	cpu       time.Duration
+}
+
+func (u resourceUsage) String() string {
+	s := fmt.Sprintf("cpu %.1fs", u.cpu.Seconds())
+	if u.hasMemory {
+		s = fmt.Sprintf("mem %.1fMiB %s", float64(u.memory)/(1<<20), s)
+	}
+	return s
+}
+
+// setupCgroups places processes in cgroups when possible, falling back to
+// process groups alone otherwise.
+func (pm3 *ProcessManager) setupCgroups() {
+	if pm3.settings.DisableCgroups {
+		return
+	}
+	cgroups, err := newCgroupManager()
+	if err != nil {
+		if !errors.Is(err, errCgroupsUnsupported) {
+			pm3.Log("Not using cgroups: %v\n", err)
+		}
+		return
+	}
+	pm3.cgroups = cgroups
+}
+
+// waitCgroups holds off exiting while anything is left in the cgroups after
+// shutdown, until the kill sweep at the end of the grace period has run.
+func (pm3 *ProcessManager) waitCgroups() {
+	if pm3.cgroups == nil {
+		return
+	}
+	ticker := time.NewTicker(adoptedPollInterval)
+	defer ticker.Stop()
+	for pm3.cgroupsPopulated() {
+		select {
+		case <-pm3.swept:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (pm3 *ProcessManager) cgroupsPopulated() bool {
+	for _, process := range pm3.snapshotProcesses() {
+		if len(pm3.cgroups.pids(process.cfg.Name)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ResourceUsage describes the memory and CPU used by the process's cgroup, or
+// "" when processes aren't placed in cgroups.
+func (pm3 *ProcessManager) ResourceUsage(index int) string {
+	if pm3.cgroups == nil {
+		return ""
+	}
+	usage, err := pm3.cgroups.usage(pm3.process(index).cfg.Name)
+	if err != nil {
+		return ""
+	}
+	return usage.String()
+}
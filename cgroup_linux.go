@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cgroupManager places each process in its own cgroup v2 group under a slice
+// for this gopm3 session, so every descendant can be found and killed, even
+// ones that left the process group with setsid().
+type cgroupManager struct {
+	slice string
+}
+
+// cgroup2Mount finds where the unified hierarchy is mounted, which is
+// /sys/fs/cgroup on pure v2 systems and often /sys/fs/cgroup/unified on
+// hybrid ones.
+func cgroup2Mount() (string, error) {
+	mountinfo, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer mountinfo.Close()
+
+	scanner := bufio.NewScanner(mountinfo)
+	for scanner.Scan() {
+		mountFields, fsFields, ok := strings.Cut(scanner.Text(), " - ")
+		if !ok || !strings.HasPrefix(fsFields, "cgroup2 ") {
+			continue
+		}
+		if fields := strings.Fields(mountFields); len(fields) > 4 {
+			return fields[4], nil
+		}
+	}
+	return "", errCgroupsUnsupported
+}
+
+func newCgroupManager() (*cgroupManager, error) {
+	mount, err := cgroup2Mount()
+	if err != nil {
+		return nil, err
+	}
+	self, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+	var base string
+	for _, line := range strings.Split(string(self), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			base = filepath.Join(mount, path)
+		}
+	}
+	if base == "" {
+		return nil, errCgroupsUnsupported
+	}
+	// Moving processes needs write access to the common ancestor.
+	if err := syscall.Access(filepath.Join(base, "cgroup.procs"), 2); err != nil {
+		return nil, fmt.Errorf("%s isn't delegated to this user: %w", base, err)
+	}
+
+	slice := filepath.Join(base, fmt.Sprintf("gopm3-%d.slice", os.Getpid()))
+	if err := os.Mkdir(slice, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return nil, err
+	}
+
+	c := &cgroupManager{slice: slice}
+
+	// Accounting needs the controllers enabled for the process groups, which
+	// only works if they are delegated down to the slice.
+	controllers, _ := os.ReadFile(filepath.Join(slice, "cgroup.controllers"))
+	for _, controller := range []string{"memory", "cpu"} {
+		if bytes.Contains(controllers, []byte(controller)) {
+			_ = os.WriteFile(filepath.Join(slice, "cgroup.subtree_control"), []byte("+"+controller), 0)
+		}
+	}
+
+	// Make sure commands can actually be started in a cgroup (this needs
+	// clone3 and permission to move processes) before relying on it.
+	if err := c.probe(); err != nil {
+		c.cleanup()
+		return nil, fmt.Errorf("starting a process in %s: %w", slice, err)
+	}
+	return c, nil
+}
+
+func (c *cgroupManager) probe() error {
+	cmd := exec.Command("/bin/sh", "-c", "exit 0")
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	release, err := c.attach(cmd, "probe")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(c.path("probe"))
+	defer release()
+	return cmd.Run()
+}
+
+func (c *cgroupManager) path(processName string) string {
+	return filepath.Join(c.slice, sanitizeProcessName(processName))
+}
+
+// attach makes cmd start inside the process's cgroup. The returned func must
+// be called once cmd has started.
+func (c *cgroupManager) attach(cmd *exec.Cmd, processName string) (func(), error) {
+	path := c.path(processName)
+	if err := os.Mkdir(path, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return nil, err
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// Starting the command straight in the cgroup means nothing it forks can
+	// escape before it is moved.
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+	return func() { dir.Close() }, nil
+}
+
+func (c *cgroupManager) pids(processName string) []int {
+	procs, err := os.ReadFile(filepath.Join(c.path(processName), "cgroup.procs"))
+	if err != nil {
+		return nil
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(procs)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// signal sends sig to every process in the cgroup and reports how many there
+// were.
+func (c *cgroupManager) signal(processName string, sig syscall.Signal) int {
+	pids := c.pids(processName)
+	for _, pid := range pids {
+		_ = syscall.Kill(pid, sig)
+	}
+	return len(pids)
+}
+
+// kill SIGKILLs everything in the cgroup.
+func (c *cgroupManager) kill(processName string) {
+	path := c.path(processName)
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	if err := os.WriteFile(filepath.Join(path, "cgroup.kill"), []byte("1"), 0); err != nil {
+		// cgroup.kill is only available since Linux 5.14.
+		c.signal(processName, syscall.SIGKILL)
+	}
+}
+
+// killMembers SIGKILLs the given pids that are still in the cgroup.
+func (c *cgroupManager) killMembers(processName string, pids []int) {
+	current := make(map[int]bool)
+	for _, pid := range c.pids(processName) {
+		current[pid] = true
+	}
+	for _, pid := range pids {
+		if current[pid] {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+}
+
+func (c *cgroupManager) usage(processName string) (resourceUsage, error) {
+	path := c.path(processName)
+	var usage resourceUsage
+
+	stat, err := os.ReadFile(filepath.Join(path, "cpu.stat"))
+	if err != nil {
+		return usage, err
+	}
+	for _, line := range strings.Split(string(stat), "\n") {
+		if value, ok := strings.CutPrefix(line, "usage_usec "); ok {
+			usec, _ := strconv.ParseUint(value, 10, 64)
+			usage.cpu = time.Duration(usec) * time.Microsecond
+		}
+	}
+	if current, err := os.ReadFile(filepath.Join(path, "memory.current")); err == nil {
+		usage.memory, _ = strconv.ParseUint(strings.TrimSpace(string(current)), 10, 64)
+		usage.hasMemory = true
+	}
+	return usage, nil
+}
+
+// cleanup removes the cgroups once every process has exited.
+func (c *cgroupManager) cleanup() {
+	entries, _ := os.ReadDir(c.slice)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			_ = os.Remove(filepath.Join(c.slice, entry.Name()))
+		}
+	}
+	_ = os.Remove(c.slice)
+}
@@ -0,0 +1,36 @@
+//go:build !linux
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+type cgroupManager struct{}
+
+func newCgroupManager() (*cgroupManager, error) {
+	return nil, errCgroupsUnsupported
+}
+
+func (c *cgroupManager) attach(cmd *exec.Cmd, processName string) (func(), error) {
+	return func() {}, nil
+}
+
+func (c *cgroupManager) signal(processName string, sig syscall.Signal) int {
+	return 0
+}
+
+func (c *cgroupManager) pids(processName string) []int {
+	return nil
+}
+
+func (c *cgroupManager) kill(processName string) {}
+
+func (c *cgroupManager) killMembers(processName string, pids []int) {}
+
+func (c *cgroupManager) usage(processName string) (resourceUsage, error) {
+	return resourceUsage{}, errCgroupsUnsupported
+}
+
+func (c *cgroupManager) cleanup() {}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ComposeConfig adopts a service from a docker compose file as a process.
+type ComposeConfig struct {
+	File    string `json:"file"`
+	Service string `json:"service"`
+	Project string `json:"project,omitempty"`
+}
+
+// projectName mirrors compose's default of the compose file's directory name,
+// so containers started outside gopm3 are adopted too.
+func (c *ComposeConfig) projectName() string {
+	if c.Project != "" {
+		return c.Project
+	}
+	dir, err := filepath.Abs(filepath.Dir(c.File))
+	if err != nil {
+		dir = filepath.Dir(c.File)
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		}
+		return -1
+	}, filepath.Base(dir))
+}
+
+// labels are the labels compose puts on the service's containers.
+func (c *ComposeConfig) labels() []string {
+	return []string{
+		"com.docker.compose.project=" + c.projectName(),
+		"com.docker.compose.service=" + c.Service,
+	}
+}
+
+func (c *ComposeConfig) args(subcommand ...string) []string {
+	args := []string{"compose", "-f", c.File, "-p", c.projectName()}
+	args = append(args, subcommand...)
+	return append(args, c.Service)
+}
+
+// composeUpArgs starts the service in the foreground so its logs follow into
+// the process pane. Its depends_on services aren't started, since nothing
+// would stop them.
+func composeUpArgs(cfg ProcessConfig) []string {
+	return cfg.Compose.args("up", "--no-deps", "--no-log-prefix")
+}
+
+// runCompose runs a `docker compose` subcommand against the process's service.
+func (pm3 *ProcessManager) runCompose(process *Process, timeout time.Duration, subcommand ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "docker", process.cfg.Compose.args(subcommand...)...)
+	writer := pm3.hookWriter(process)
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	if err := children.run(cmd); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("docker compose %s timed out after %s", subcommand[0], timeout)
+		}
+		return fmt.Errorf("docker compose %s: %w", subcommand[0], err)
+	}
+	return nil
+}
+
+func (pm3 *ProcessManager) stopComposeService(process *Process) error {
+	seconds := strconv.Itoa(int(dockerStopTimeout.Seconds()))
+	err := pm3.runCompose(process, dockerStopTimeout+dockerAPITimeout, "stop", "-t", seconds)
+	if err != nil {
+		pm3.Log("Could not stop compose service for '%s', killing it: %v\n", process.cfg.Name, err)
+		return pm3.killComposeService(process)
+	}
+	return nil
+}
+
+func (pm3 *ProcessManager) killComposeService(process *Process) error {
+	return pm3.runCompose(process, dockerKillTimeout, "kill")
+}
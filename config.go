@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Config is the parsed config. Each file is either an object with "include",
+// "settings" and "processes", or just the array of processes.
+type Config struct {
+	Vars      map[string]string `json:"vars,omitempty"`
+	Settings  Settings          `json:"settings"`
+	Processes []ProcessConfig   `json:"processes"`
+}
+
+// Settings apply to the whole gopm3 session.
+type Settings struct {
+	KillGracePeriod int               `json:"kill_grace_period,omitempty"`
+	RestartDelay    int               `json:"restart_delay,omitempty"`
+	StateDir        string            `json:"state_dir,omitempty"`
+	DisableLogs     bool              `json:"disable_logs,omitempty"`
+	Scrollback      int               `json:"scrollback,omitempty"`
+	LogScrollback   int               `json:"log_scrollback,omitempty"`
+	ProxyListen     string            `json:"proxy_listen,omitempty"`
+	DisableCgroups  bool              `json:"disable_cgroups,omitempty"`
+	CrashLoopExits  int               `json:"crash_loop_exits,omitempty"`
+	CrashLoopWindow int               `json:"crash_loop_window,omitempty"`
+	Theme           Theme             `json:"theme,omitempty"`
+	Keybindings     map[string]string `json:"keybindings,omitempty"`
+
+	Notifications NotificationSettings `json:"notifications,omitempty"`
+}
+
+// Theme overrides the TUI colors. Colors are names ("darkcyan") or hex
+// ("#1e1e2e").
+type Theme struct {
+	Background string `json:"background,omitempty"`
+	Text       string `json:"text,omitempty"`
+	Border     string `json:"border,omitempty"`
+	Title      string `json:"title,omitempty"`
+	Selected   string `json:"selected,omitempty"`
+}
+
+// configFile is a single file of the config, before includes and overrides
+// are merged.
+type configFile struct {
+	Include   []string          `json:"include"`
+	Vars      json.RawMessage   `json:"vars"`
+	Settings  json.RawMessage   `json:"settings"`
+	Processes []json.RawMessage `json:"processes"`
+}
+
+// configLoader merges config files. Later files override earlier ones field by
+// field: settings are decoded on top of each other, and each process is
+// decoded from every entry with its name in order. Objects and maps are
+// merged, everything else (including lists such as args) is replaced.
+type configLoader struct {
+	settings Settings
+	vars     map[string]string
+	names    []string
+	entries  map[string][]json.RawMessage
+	loading  map[string]bool
+}
+
+// localConfigPath is the per-user overlay loaded after the config, e.g.
+// gopm3.config.local.json next to gopm3.config.json.
+func localConfigPath(cfgPath string) string {
+	return strings.TrimSuffix(cfgPath, filepath.Ext(cfgPath)) + ".local.json"
+}
+
+// loadConfig reads the config at cfgPath along with everything it includes and
+// the local overlay, if there is one.
+func loadConfig(cfgPath string) (*Config, error) {
+	loader := &configLoader{
+		entries: make(map[string][]json.RawMessage),
+		loading: make(map[string]bool),
+	}
+	if _, err := os.Stat(cfgPath); err != nil {
+		return nil, fmt.Errorf("Missing config file: %s", cfgPath)
+	}
+	if err := loader.load(cfgPath); err != nil {
+		return nil, err
+	}
+	if localPath := localConfigPath(cfgPath); fileExists(localPath) {
+		if err := loader.load(localPath); err != nil {
+			return nil, err
+		}
+	}
+	return loader.config()
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (l *configLoader) load(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if l.loading[abs] {
+		return fmt.Errorf("%s: include cycle", path)
+	}
+	l.loading[abs] = true
+	defer delete(l.loading, abs)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file configFile
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &file.Processes)
+	} else {
+		err = json.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	// Included files come first so the including file can override them.
+	for _, include := range file.Include {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		if err := l.load(include); err != nil {
+			return err
+		}
+	}
+
+	if len(file.Vars) > 0 {
+		if err := json.Unmarshal(file.Vars, &l.vars); err != nil {
+			return fmt.Errorf("%s: vars: %w", path, err)
+		}
+	}
+	if len(file.Settings) > 0 {
+		if err := json.Unmarshal(file.Settings, &l.settings); err != nil {
+			return fmt.Errorf("%s: settings: %w", path, err)
+		}
+	}
+	for i, raw := range file.Processes {
+		var entry struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return fmt.Errorf("%s: process %d: %w", path, i+1, err)
+		}
+		if entry.Name == "" {
+			return fmt.Errorf("%s: process %d has no name", path, i+1)
+		}
+		if _, ok := l.entries[entry.Name]; !ok {
+			l.names = append(l.names, entry.Name)
+		}
+		l.entries[entry.Name] = append(l.entries[entry.Name], raw)
+	}
+	return nil
+}
+
+func (l *configLoader) config() (*Config, error) {
+	cfg := &Config{Vars: l.vars, Settings: l.settings}
+	for _, name := range l.names {
+		// Fields missing from the process take the settings defaults.
+		process := ProcessConfig{RestartDelay: l.settings.RestartDelay}
+		for _, raw := range l.entries[name] {
+			if err := json.Unmarshal(raw, &process); err != nil {
+				return nil, fmt.Errorf("process '%s': %w", name, err)
+			}
+		}
+		if process.Disabled {
+			continue
+		}
+		cfg.Processes = append(cfg.Processes, process)
+	}
+	return cfg, nil
+}
+
+// printConfig writes the merged config as JSON.
+func printConfig(w io.Writer, cfg *Config) error {
+	encoded, err := json.MarshalIndent(cfg, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", encoded)
+	return err
+}
+
+// apply sets the package level knobs controlled by the settings.
+func (s Settings) apply() error {
+	if s.KillGracePeriod > 0 {
+		SigKillGracePeriod = time.Duration(s.KillGracePeriod) * time.Millisecond
+	}
+	if s.Scrollback > 0 {
+		ProcessScrollback = s.Scrollback
+	}
+	if s.LogScrollback > 0 {
+		LogScrollback = s.LogScrollback
+	}
+	if err := s.Notifications.validate(); err != nil {
+		return err
+	}
+	return s.Theme.apply()
+}
+
+func parseColor(name string) (tcell.Color, error) {
+	if name == "default" {
+		return tcell.ColorDefault, nil
+	}
+	color := tcell.GetColor(name)
+	if color == tcell.ColorDefault {
+		return color, fmt.Errorf("unknown color %q", name)
+	}
+	return color, nil
+}
+
+// apply sets the tview styles, so it must run before any widget is created.
+func (t Theme) apply() error {
+	colors := []struct {
+		name   string
+		styles []*tcell.Color
+	}{
+		{t.Background, []*tcell.Color{&tview.Styles.PrimitiveBackgroundColor}},
+		{t.Text, []*tcell.Color{&tview.Styles.PrimaryTextColor}},
+		{t.Border, []*tcell.Color{&tview.Styles.BorderColor, &tview.Styles.GraphicsColor}},
+		{t.Title, []*tcell.Color{&tview.Styles.TitleColor}},
+	}
+	for _, c := range colors {
+		if c.name == "" {
+			continue
+		}
+		color, err := parseColor(c.name)
+		if err != nil {
+			return fmt.Errorf("theme: %w", err)
+		}
+		for _, style := range c.styles {
+			*style = color
+		}
+	}
+	if t.Selected != "" {
+		if _, err := parseColor(t.Selected); err != nil {
+			return fmt.Errorf("theme: %w", err)
+		}
+	}
+	return nil
+}
+
+// applySelected colors the highlighted row of a list.
+func (t Theme) applySelected(list *tview.List) {
+	if t.Selected == "" {
+		return
+	}
+	color, _ := parseColor(t.Selected)
+	list.SetSelectedBackgroundColor(color)
+}
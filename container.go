@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ContainerConfig declares a process that runs a single docker container.
+// gopm3 builds the `docker run` itself, so the container can be tracked by
+// its cidfile and removed when the process stops.
+type ContainerConfig struct {
+	Image   string            `json:"image"`
+	Name    string            `json:"name,omitempty"`
+	Ports   []string          `json:"ports,omitempty"`
+	Volumes []string          `json:"volumes,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+}
+
+func (c *ContainerConfig) containerName(processName string) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return fmt.Sprintf("gopm3_%s_%s", stateDirID(), sanitizeProcessName(processName))
+}
+
+// containerRunArgs builds the `docker run` arguments for a container process.
+// The process command and args, if any, override the image's command.
+func containerRunArgs(cfg ProcessConfig, cidFile string) []string {
+	c := cfg.Container
+	args := []string{"run", "--rm", "--name", c.containerName(cfg.Name), "--cidfile", cidFile}
+	for _, port := range c.Ports {
+		args = append(args, "-p", port)
+	}
+	for _, volume := range c.Volumes {
+		args = append(args, "-v", volume)
+	}
+
+	// Keep the generated command stable between runs.
+	keys := make([]string, 0, len(c.Env))
+	for k := range c.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "-e", k+"="+c.Env[k])
+	}
+
+	args = append(args, c.Image)
+	if cfg.Command != "" {
+		args = append(args, cfg.Command)
+	}
+	return append(args, cfg.Args...)
+}
+
+// removeNamedContainer clears out a container left by an earlier run of the
+// process that would clash with the name of the container about to be started.
+// Containers that aren't labelled as this project's are left alone.
+func (pm3 *ProcessManager) removeNamedContainer(process *Process) {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerAPITimeout)
+	defer cancel()
+
+	name := process.cfg.Container.containerName(process.cfg.Name)
+	labels, err := pm3.docker.Labels(ctx, name)
+	if errors.Is(err, errContainerGone) {
+		return
+	}
+	if err != nil {
+		pm3.Log("Could not inspect existing container '%s' for '%s': %v\n", name, process.cfg.Name, err)
+		return
+	}
+	if !ownsDockerLabel(labels[dockerProcessLabel], process.cfg.Name) {
+		pm3.Log("Not removing existing container '%s' for '%s', it doesn't belong to this project\n", name, process.cfg.Name)
+		return
+	}
+	if err := pm3.docker.Remove(ctx, name, true); err == nil {
+		pm3.Log("Removed existing container '%s' for '%s'\n", name, process.cfg.Name)
+	} else if !errors.Is(err, errContainerGone) {
+		pm3.Log("Could not remove existing container '%s' for '%s': %v\n", name, process.cfg.Name, err)
+	}
+}
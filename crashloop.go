@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCrashLoopExits  = 5
+	defaultCrashLoopWindow = time.Minute
+
+	// Output lines shown in the gopm3 log when a crash loop is detected.
+	crashLoopOutputLines = 10
+	// How far back in the log file to look for them.
+	crashLoopOutputBytes = 16 << 10
+)
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+func (s Settings) crashLoopExits() int {
+	if s.CrashLoopExits != 0 {
+		return s.CrashLoopExits
+	}
+	return defaultCrashLoopExits
+}
+
+func (s Settings) crashLoopWindow() time.Duration {
+	if s.CrashLoopWindow > 0 {
+		return time.Duration(s.CrashLoopWindow) * time.Millisecond
+	}
+	return defaultCrashLoopWindow
+}
+
+// crashLooping records an exit and reports whether the process has exited
+// often enough within the window to stop restarting it.
+func (s *supervisor) crashLooping() bool {
+	settings := s.pm3.settings
+	limit := settings.crashLoopExits()
+	if limit < 0 {
+		return false
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-settings.crashLoopWindow())
+	recent := s.exits[:0]
+	for _, at := range s.exits {
+		if at.After(cutoff) {
+			recent = append(recent, at)
+		}
+	}
+	s.exits = append(recent, now)
+	return len(s.exits) >= limit
+}
+
+// reportCrashLoop logs the crash loop along with the last lines the process
+// wrote, which usually say why it keeps exiting.
+func (pm3 *ProcessManager) reportCrashLoop(index int, exits int) {
+	process := pm3.process(index)
+	pm3.Log("Process '%s' exited %d times within %s, not restarting it until it's restarted manually\n",
+		process.cfg.Name, exits, pm3.settings.crashLoopWindow())
+	lines := process.lastOutput(crashLoopOutputLines)
+	if len(lines) == 0 {
+		return
+	}
+	pm3.Log("Last output of '%s':\n", process.cfg.Name)
+	for _, line := range lines {
+		pm3.Log("  %s\n", line)
+	}
+}
+
+// lastOutput returns up to n of the last non-empty lines of the process log.
+func (process *Process) lastOutput(n int) []string {
+	info, err := process.logFile.Stat()
+	if err != nil {
+		return nil
+	}
+	offset := max(info.Size()-crashLoopOutputBytes, 0)
+	data := make([]byte, info.Size()-offset)
+	if _, err := process.logFile.ReadAt(data, offset); err != nil && err != io.EOF {
+		return nil
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(ansiEscape.ReplaceAllString(line, ""), " \r\t")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	// The first line may have been cut by the offset.
+	if offset > 0 && len(lines) > 0 {
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dockerStopTimeout = 10 * time.Second
+	dockerAPITimeout  = 5 * time.Second
+
+	// Every container started through an injected `docker run` carries this
+	// label so it can be found without guessing.
+	dockerProcessLabel = "io.gopm3.process"
+)
+
+var (
+	errContainerGone = errors.New("no such container")
+
+	// Distinguishes this gopm3 session's containers from any other instance.
+	dockerSessionID = fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+)
+
+// DockerClient is a minimal Docker Engine API client that talks to the daemon
+// over its Unix socket.
+type DockerClient struct {
+	http *http.Client
+}
+
+type dockerEvent struct {
+	Action string `json:"Action"`
+	ID     string `json:"id"`
+	Actor  struct {
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+}
+
+func dockerSocketPath() string {
+	if host := os.Getenv("DOCKER_HOST"); strings.HasPrefix(host, "unix://") {
+		return strings.TrimPrefix(host, "unix://")
+	}
+	return "/var/run/docker.sock"
+}
+
+func NewDockerClient(socketPath string) *DockerClient {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	return &DockerClient{http: &http.Client{Transport: transport}}
+}
+
+func (c *DockerClient) do(ctx context.Context, method, path string, query url.Values) (*http.Response, error) {
+	u := "http://docker" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 || resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errContainerGone
+	}
+	var apiErr struct {
+		Message string `json:"message"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Message != "" {
+		return nil, fmt.Errorf("docker: %s", apiErr.Message)
+	}
+	return nil, fmt.Errorf("docker: %s %s: %s", method, path, resp.Status)
+}
+
+func dockerFilters(filters map[string][]string) url.Values {
+	if len(filters) == 0 {
+		return nil
+	}
+	encoded, _ := json.Marshal(filters)
+	return url.Values{"filters": {string(encoded)}}
+}
+
+// ContainerIDs lists the IDs of running containers matching the filters.
+func (c *DockerClient) ContainerIDs(ctx context.Context, filters map[string][]string) (map[string]struct{}, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/containers/json", dockerFilters(filters))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var containers []struct {
+		ID string `json:"Id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, err
+	}
+	ids := make(map[string]struct{}, len(containers))
+	for _, container := range containers {
+		ids[container.ID] = struct{}{}
+	}
+	return ids, nil
+}
+
+// Labels returns the labels of a container, by ID or name.
+func (c *DockerClient) Labels(ctx context.Context, id string) (map[string]string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+id+"/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var container struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&container); err != nil {
+		return nil, err
+	}
+	return container.Config.Labels, nil
+}
+
+// Stop behaves like `docker stop -t`: SIGTERM, then SIGKILL after timeout.
+func (c *DockerClient) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	query := url.Values{"t": {strconv.Itoa(int(timeout.Seconds()))}}
+	resp, err := c.do(ctx, http.MethodPost, "/containers/"+id+"/stop", query)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *DockerClient) Kill(ctx context.Context, id string) error {
+	resp, err := c.do(ctx, http.MethodPost, "/containers/"+id+"/kill", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Remove deletes a container, killing it first when force is set.
+func (c *DockerClient) Remove(ctx context.Context, id string, force bool) error {
+	query := url.Values{"force": {strconv.FormatBool(force)}}
+	resp, err := c.do(ctx, http.MethodDelete, "/containers/"+id, query)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Events streams container events for a single container until ctx is done.
+func (c *DockerClient) Events(ctx context.Context, id string) (<-chan dockerEvent, error) {
+	query := dockerFilters(map[string][]string{"type": {"container"}, "container": {id}})
+	resp, err := c.do(ctx, http.MethodGet, "/events", query)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan dockerEvent)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		decoder := json.NewDecoder(bufio.NewReader(resp.Body))
+		for {
+			var event dockerEvent
+			if err := decoder.Decode(&event); err != nil {
+				return
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+func dockerCIDFilePath(processName string) string {
+	return statePath(sanitizeProcessName(processName) + ".cid")
+}
+
+// dockerLabelValue is unique per run, so a container from a previous run
+// that's still shutting down is never mistaken for the new one. It starts with
+// the project and process so leftovers can be told apart from other projects'.
+func dockerLabelValue(processName string) string {
+	return fmt.Sprintf("%s/%s/%s/%d", stateDirID(), sanitizeProcessName(processName), dockerSessionID, time.Now().UnixNano())
+}
+
+// ownsDockerLabel reports whether a label value was made by dockerLabelValue
+// for the process in this project.
+func ownsDockerLabel(value, processName string) bool {
+	return strings.HasPrefix(value, stateDirID()+"/"+sanitizeProcessName(processName)+"/")
+}
+
+// prepareDockerRun injects the gopm3 label into `docker run` commands and
+// returns the args to use along with any --cidfile the user passed. Commands
+// that aren't a direct `docker run` (scripts, compose wrappers) are returned
+// unchanged and can opt in by passing --label "$GOPM3_DOCKER_LABEL" themselves.
+func prepareDockerRun(command string, args []string, label string) ([]string, string, bool) {
+	runAt := -1
+	if command == "docker" {
+		if len(args) > 0 && args[0] == "run" {
+			runAt = 0
+		} else if len(args) > 1 && args[0] == "container" && args[1] == "run" {
+			runAt = 1
+		}
+	}
+	if runAt < 0 {
+		return args, "", false
+	}
+
+	var cidFile string
+	for i, arg := range args {
+		if value, ok := strings.CutPrefix(arg, "--cidfile="); ok {
+			cidFile = value
+		} else if arg == "--cidfile" && i+1 < len(args) {
+			cidFile = args[i+1]
+		}
+	}
+
+	injected := make([]string, 0, len(args)+2)
+	injected = append(injected, args[:runAt+1]...)
+	injected = append(injected, "--label", label)
+	injected = append(injected, args[runAt+1:]...)
+	return injected, cidFile, true
+}
+
+func readCIDFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// findDockerContainerID resolves the container started by a docker-managed
+// process: from the user's --cidfile, then by the injected label, and only as
+// a last resort by diffing the running containers against a snapshot.
+func (pm3 *ProcessManager) findDockerContainerID(process *Process, before map[string]struct{}) (string, error) {
+	labelFilter := map[string][]string{"label": {process.dockerLabel}}
+	if process.cfg.Compose != nil {
+		labelFilter["label"] = process.cfg.Compose.labels()
+	}
+
+	deadline := time.Now().Add(dockerDetectTimeout)
+	for time.Now().Before(deadline) {
+		if process.userCIDFile != "" {
+			if id := readCIDFile(process.userCIDFile); id != "" {
+				return id, nil
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), dockerAPITimeout)
+		labelled, err := pm3.docker.ContainerIDs(ctx, labelFilter)
+		if err == nil {
+			for id := range labelled {
+				cancel()
+				return id, nil
+			}
+		}
+		if before != nil {
+			after, err := pm3.docker.ContainerIDs(ctx, nil)
+			if err == nil {
+				for id := range after {
+					if _, seen := before[id]; !seen {
+						cancel()
+						return id, nil
+					}
+				}
+			}
+		}
+		cancel()
+		time.Sleep(dockerDetectPoll)
+	}
+	return "", fmt.Errorf("no container found within %s", dockerDetectTimeout)
+}
+
+func (pm3 *ProcessManager) captureDockerContainerID(process *Process, before map[string]struct{}) (string, error) {
+	if !process.cfg.DockerManaged || process.dockerCIDFile == "" {
+		return "", nil
+	}
+
+	containerID, err := pm3.findDockerContainerID(process, before)
+	if err != nil {
+		return "", err
+	}
+	return containerID, os.WriteFile(process.dockerCIDFile, []byte(containerID), 0644)
+}
+
+// watchDockerEvents logs state changes of the process's container until ctx is done.
+func (pm3 *ProcessManager) watchDockerEvents(ctx context.Context, process *Process, containerID string) {
+	events, err := pm3.docker.Events(ctx, containerID)
+	if err != nil {
+		pm3.Log("Could not follow docker events for '%s': %v\n", process.cfg.Name, err)
+		return
+	}
+
+	for event := range events {
+		if strings.HasPrefix(event.Action, "exec_") || event.Action == "attach" || event.Action == "resize" {
+			continue
+		}
+		if code, ok := event.Actor.Attributes["exitCode"]; ok {
+			pm3.Log("Container %.12s for '%s': %s (exit code %s)\n", containerID, process.cfg.Name, event.Action, code)
+		} else {
+			pm3.Log("Container %.12s for '%s': %s\n", containerID, process.cfg.Name, event.Action)
+		}
+	}
+}
+
+func (pm3 *ProcessManager) dockerContainerID(process *Process) string {
+	if !process.cfg.DockerManaged || process.dockerCIDFile == "" {
+		return ""
+	}
+	return readCIDFile(process.dockerCIDFile)
+}
+
+// stopDockerContainer stops the process's container with `docker stop -t`
+// semantics, falling back to a kill if the daemon couldn't stop it.
+func (pm3 *ProcessManager) stopDockerContainer(process *Process) error {
+	if process.cfg.Compose != nil {
+		return pm3.stopComposeService(process)
+	}
+
+	containerID := pm3.dockerContainerID(process)
+	if containerID == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerStopTimeout+dockerAPITimeout)
+	defer cancel()
+
+	err := pm3.docker.Stop(ctx, containerID, dockerStopTimeout)
+	if err != nil && !errors.Is(err, errContainerGone) {
+		pm3.Log("Could not stop docker container for '%s', killing it: %v\n", process.cfg.Name, err)
+		return pm3.killDockerContainer(process)
+	}
+	pm3.removeContainer(process, containerID)
+	_ = os.Remove(process.dockerCIDFile)
+	return nil
+}
+
+// removeContainer deletes the container of a `container` process, which
+// gopm3 owns outright, once it has stopped.
+func (pm3 *ProcessManager) removeContainer(process *Process, containerID string) {
+	if process.cfg.Container == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerAPITimeout)
+	defer cancel()
+	if err := pm3.docker.Remove(ctx, containerID, true); err != nil && !errors.Is(err, errContainerGone) {
+		pm3.Log("Could not remove docker container for '%s': %v\n", process.cfg.Name, err)
+	}
+}
+
+func (pm3 *ProcessManager) killDockerContainer(process *Process) error {
+	if process.cfg.Compose != nil {
+		return pm3.killComposeService(process)
+	}
+
+	containerID := pm3.dockerContainerID(process)
+	if containerID == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerKillTimeout)
+	defer cancel()
+
+	err := pm3.docker.Kill(ctx, containerID)
+	if err == nil || errors.Is(err, errContainerGone) || strings.Contains(err.Error(), "is not running") {
+		pm3.removeContainer(process, containerID)
+		_ = os.Remove(process.dockerCIDFile)
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out waiting for docker kill")
+	}
+	return err
+}
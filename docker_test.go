@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newFakeDocker serves handler on a Unix socket, like the docker daemon, and
+// returns a client for it.
+func newFakeDocker(t *testing.T, handler http.HandlerFunc) *DockerClient {
+	t.Helper()
+	// Socket paths are limited to ~100 bytes, t.TempDir() can be too long.
+	dir, err := os.MkdirTemp("", "gopm3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return NewDockerClient(socket)
+}
+
+func TestDockerContainerIDsFilters(t *testing.T) {
+	client := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/containers/json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var filters map[string][]string
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters); err != nil {
+			t.Errorf("bad filters %q: %v", r.URL.Query().Get("filters"), err)
+		}
+		want := map[string][]string{"label": {"io.gopm3.process=x"}}
+		if !reflect.DeepEqual(filters, want) {
+			t.Errorf("filters = %v, want %v", filters, want)
+		}
+		fmt.Fprint(w, `[{"Id": "aaa"}, {"Id": "bbb"}]`)
+	})
+
+	ids, err := client.ContainerIDs(context.Background(), map[string][]string{"label": {"io.gopm3.process=x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]struct{}{"aaa": {}, "bbb": {}}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("ids = %v, want %v", ids, want)
+	}
+}
+
+func TestDockerContainerIDsNoFilters(t *testing.T) {
+	client := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery != "" {
+			t.Errorf("query = %q, want none", r.URL.RawQuery)
+		}
+		fmt.Fprint(w, `[]`)
+	})
+
+	ids, err := client.ContainerIDs(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("ids = %v, want none", ids)
+	}
+}
+
+func TestDockerStop(t *testing.T) {
+	client := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/containers/abc/stop" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.URL.Query().Get("t"); got != "10" {
+			t.Errorf("t = %q, want 10", got)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	if err := client.Stop(context.Background(), "abc", 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDockerStopAlreadyStopped(t *testing.T) {
+	client := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	})
+
+	if err := client.Stop(context.Background(), "abc", time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDockerNotFound(t *testing.T) {
+	client := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message": "No such container: abc"}`)
+	})
+	ctx := context.Background()
+
+	if err := client.Stop(ctx, "abc", time.Second); !errors.Is(err, errContainerGone) {
+		t.Errorf("Stop: err = %v, want errContainerGone", err)
+	}
+	if err := client.Kill(ctx, "abc"); !errors.Is(err, errContainerGone) {
+		t.Errorf("Kill: err = %v, want errContainerGone", err)
+	}
+	if err := client.Remove(ctx, "abc", true); !errors.Is(err, errContainerGone) {
+		t.Errorf("Remove: err = %v, want errContainerGone", err)
+	}
+	if _, err := client.Labels(ctx, "abc"); !errors.Is(err, errContainerGone) {
+		t.Errorf("Labels: err = %v, want errContainerGone", err)
+	}
+}
+
+func TestDockerAPIError(t *testing.T) {
+	client := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"message": "container abc is not running"}`)
+	})
+
+	err := client.Kill(context.Background(), "abc")
+	if err == nil || err.Error() != "docker: container abc is not running" {
+		t.Errorf("err = %v, want the daemon's message", err)
+	}
+}
+
+func TestDockerLabels(t *testing.T) {
+	client := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers/gopm3_db/json" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		fmt.Fprint(w, `{"Config": {"Labels": {"io.gopm3.process": "x"}}}`)
+	})
+
+	labels, err := client.Labels(context.Background(), "gopm3_db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels[dockerProcessLabel] != "x" {
+		t.Errorf("labels = %v", labels)
+	}
+}
+
+func TestDockerEvents(t *testing.T) {
+	client := newFakeDocker(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/events" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var filters map[string][]string
+		json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+		want := map[string][]string{"type": {"container"}, "container": {"abc"}}
+		if !reflect.DeepEqual(filters, want) {
+			t.Errorf("filters = %v, want %v", filters, want)
+		}
+
+		// Events are streamed one at a time.
+		fmt.Fprintln(w, `{"Action": "start", "id": "abc"}`)
+		w.(http.Flusher).Flush()
+		fmt.Fprintln(w, `{"Action": "die", "id": "abc", "Actor": {"Attributes": {"exitCode": "1"}}}`)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events, err := client.Events(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []dockerEvent
+	for event := range events {
+		got = append(got, event)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d events, want 2: %+v", len(got), got)
+	}
+	if got[0].Action != "start" || got[1].Action != "die" || got[1].Actor.Attributes["exitCode"] != "1" {
+		t.Errorf("events = %+v", got)
+	}
+}
+
+func TestPrepareDockerRun(t *testing.T) {
+	const label = "io.gopm3.process=x"
+	tests := []struct {
+		name     string
+		command  string
+		args     []string
+		wantArgs []string
+		wantCID  string
+		wantOK   bool
+	}{
+		{
+			name:     "docker run",
+			command:  "docker",
+			args:     []string{"run", "--rm", "redis"},
+			wantArgs: []string{"run", "--label", label, "--rm", "redis"},
+			wantOK:   true,
+		},
+		{
+			name:     "docker container run",
+			command:  "docker",
+			args:     []string{"container", "run", "redis"},
+			wantArgs: []string{"container", "run", "--label", label, "redis"},
+			wantOK:   true,
+		},
+		{
+			name:     "cidfile with equals",
+			command:  "docker",
+			args:     []string{"run", "--cidfile=/tmp/db.cid", "postgres"},
+			wantArgs: []string{"run", "--label", label, "--cidfile=/tmp/db.cid", "postgres"},
+			wantCID:  "/tmp/db.cid",
+			wantOK:   true,
+		},
+		{
+			name:     "cidfile as separate arg",
+			command:  "docker",
+			args:     []string{"run", "--cidfile", "/tmp/db.cid", "postgres"},
+			wantArgs: []string{"run", "--label", label, "--cidfile", "/tmp/db.cid", "postgres"},
+			wantCID:  "/tmp/db.cid",
+			wantOK:   true,
+		},
+		{
+			name:     "other docker command",
+			command:  "docker",
+			args:     []string{"compose", "up"},
+			wantArgs: []string{"compose", "up"},
+		},
+		{
+			name:     "wrapper script",
+			command:  "./run-db.sh",
+			args:     []string{"run"},
+			wantArgs: []string{"run"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, cid, ok := prepareDockerRun(tt.command, tt.args, label)
+			if !reflect.DeepEqual(args, tt.wantArgs) || cid != tt.wantCID || ok != tt.wantOK {
+				t.Errorf("prepareDockerRun() = %q, %q, %v, want %q, %q, %v", args, cid, ok, tt.wantArgs, tt.wantCID, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+const (
+	HookPreStart  = "pre_start"
+	HookPostStart = "post_start"
+	HookPreStop   = "pre_stop"
+	HookPostStop  = "post_stop"
+
+	defaultHookTimeout = 60 * time.Second
+)
+
+var (
+	errPreStartFailed = errors.New("pre_start hook failed")
+	errStartAborted   = errors.New("stopped before it could start")
+)
+
+func (cfg ProcessConfig) hookCommand(hook string) string {
+	switch hook {
+	case HookPreStart:
+		return cfg.PreStart
+	case HookPostStart:
+		return cfg.PostStart
+	case HookPreStop:
+		return cfg.PreStop
+	case HookPostStop:
+		return cfg.PostStop
+	}
+	return ""
+}
+
+func (cfg ProcessConfig) hookTimeout() time.Duration {
+	if cfg.HookTimeout > 0 {
+		return time.Duration(cfg.HookTimeout) * time.Millisecond
+	}
+	return defaultHookTimeout
+}
+
+// hookWriter mirrors the process output destinations so hook output shows up
+// alongside the process logs.
+func (pm3 *ProcessManager) hookWriter(process *Process) io.Writer {
+	if pm3.disableLogs || process.cfg.DisableLogs {
+		return process.logFile
+	}
+	return io.MultiWriter(process.logFile, tview.ANSIWriter(process.textView))
+}
+
+// runHook runs the given lifecycle hook, if configured.
+func (pm3 *ProcessManager) runHook(process *Process, hook string) error {
+	command := process.cfg.hookCommand(hook)
+	if command == "" {
+		return nil
+	}
+	return pm3.runHookCommand(process, hook, command)
+}
+
+// runHookCommand runs command through `sh -c` with the process's hook timeout
+// and returns an error when it fails or times out.
+func (pm3 *ProcessManager) runHookCommand(process *Process, hook, command string) error {
+	timeout := process.cfg.hookTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	writer := pm3.hookWriter(process)
+	fmt.Fprintf(writer, "---- %s: %s ----\n", hook, command)
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = process.cfg.Cwd
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// Take down anything the hook spawned, not just the shell.
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+
+	err := children.run(cmd)
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		fmt.Fprintf(writer, "---- %s failed: %v ----\n", hook, err)
+		pm3.Log("Hook %s for '%s' failed: %v\n", hook, process.cfg.Name, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Variables that are left in place by the config loader and expanded later,
+// per replica. ${ports.<process>.<port>} is also left for once ports are
+// allocated.
+var deferredVars = map[string]bool{
+	"NAME":        true,
+	"INSTANCE":    true,
+	"PORT_OFFSET": true,
+}
+
+func isDeferredVar(name string) bool {
+	return deferredVars[name] || strings.HasPrefix(name, "ports.")
+}
+
+// nextVar finds the next ${VAR} in s, returning its bounds and whether it's
+// escaped as `$${VAR}`.
+func nextVar(s string) (start, end int, name string, escaped, ok bool) {
+	start = strings.Index(s, "${")
+	if start < 0 {
+		return 0, 0, "", false, false
+	}
+	length := strings.IndexByte(s[start+2:], '}')
+	if length < 0 {
+		return 0, 0, "", false, false
+	}
+	end = start + 2 + length + 1
+	escaped = start > 0 && s[start-1] == '$'
+	return start, end, s[start+2 : end-1], escaped, true
+}
+
+// expandVars replaces each ${VAR} in s using lookup. `$${VAR}` is kept as a
+// literal `${VAR}`, e.g. for shell commands that expand it themselves. Escaped
+// deferred variables keep their escape for the pass that expands them.
+func expandVars(s string, lookup func(string) (string, bool)) (string, error) {
+	var b strings.Builder
+	for {
+		start, end, name, escaped, ok := nextVar(s)
+		if !ok {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if escaped {
+			if isDeferredVar(name) {
+				b.WriteString(s[:end])
+			} else {
+				b.WriteString(s[:start-1])
+				b.WriteString(s[start:end])
+			}
+			s = s[end:]
+			continue
+		}
+
+		value, ok := lookup(name)
+		switch {
+		case ok:
+			b.WriteString(s[:start])
+			b.WriteString(value)
+		case isDeferredVar(name):
+			b.WriteString(s[:end])
+		default:
+			return "", fmt.Errorf("undefined variable ${%s}", name)
+		}
+		s = s[end:]
+	}
+}
+
+// expandDeferredVars replaces the deferred variables lookup knows in s and
+// turns escaped ones into literals. Everything else is left as it is.
+func expandDeferredVars(s string, lookup func(string) (string, bool)) string {
+	var b strings.Builder
+	for {
+		start, end, name, escaped, ok := nextVar(s)
+		if !ok {
+			b.WriteString(s)
+			return b.String()
+		}
+		value, known := lookup(name)
+		switch {
+		case escaped && deferredVars[name]:
+			b.WriteString(s[:start-1])
+			b.WriteString(s[start:end])
+		case !escaped && known:
+			b.WriteString(s[:start])
+			b.WriteString(value)
+		default:
+			b.WriteString(s[:end])
+		}
+		s = s[end:]
+	}
+}
+
+// interpolate expands variables in the command, args, env and cwd of every
+// process. Variables are looked up in order from the built-ins (PROJECT_ROOT
+// and STATE_DIR, NAME is left for each replica), the process's env (except
+// within env itself), the vars map and finally gopm3's own environment.
+func (cfg *Config) interpolate(cfgPath, stateDir string) error {
+	projectRoot, err := filepath.Abs(filepath.Dir(cfgPath))
+	if err != nil {
+		return err
+	}
+	// Processes may run in another directory, so don't hand out relative paths.
+	if stateDir, err = filepath.Abs(stateDir); err != nil {
+		return err
+	}
+	builtins := map[string]string{
+		"PROJECT_ROOT": projectRoot,
+		"STATE_DIR":    stateDir,
+	}
+	global := func(name string) (string, bool) {
+		if value, ok := builtins[name]; ok {
+			return value, true
+		}
+		return os.LookupEnv(name)
+	}
+
+	var errs []error
+	vars := make(map[string]string, len(cfg.Vars))
+	for name, value := range cfg.Vars {
+		expanded, err := expandVars(value, global)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("vars: %s: %w", name, err))
+		}
+		vars[name] = expanded
+	}
+	cfg.Vars = vars
+	withVars := func(name string) (string, bool) {
+		if value, ok := builtins[name]; ok {
+			return value, true
+		}
+		if value, ok := vars[name]; ok {
+			return value, true
+		}
+		return os.LookupEnv(name)
+	}
+
+	for i := range cfg.Processes {
+		process := &cfg.Processes[i]
+		fail := func(field string, err error) {
+			errs = append(errs, fmt.Errorf("process '%s': %s: %w", process.Name, field, err))
+		}
+
+		// Sorted so errors are reported in a stable order.
+		keys := make([]string, 0, len(process.Env))
+		for k := range process.Env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			value, err := expandVars(process.Env[k], withVars)
+			if err != nil {
+				fail("env."+k, err)
+			}
+			process.Env[k] = value
+		}
+
+		lookup := func(name string) (string, bool) {
+			// The process's own allocated ports aren't known yet.
+			for _, portName := range process.portNames() {
+				if process.Ports[portName] == name {
+					return "${ports." + process.Name + "." + portName + "}", true
+				}
+			}
+			if _, ok := builtins[name]; !ok {
+				if value, ok := process.Env[name]; ok {
+					return value, true
+				}
+			}
+			return withVars(name)
+		}
+		if process.Command, err = expandVars(process.Command, lookup); err != nil {
+			fail("command", err)
+		}
+		for j, arg := range process.Args {
+			if process.Args[j], err = expandVars(arg, lookup); err != nil {
+				fail(fmt.Sprintf("args[%d]", j), err)
+			}
+		}
+		if process.Cwd, err = expandVars(process.Cwd, lookup); err != nil {
+			fail("cwd", err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestDeferredVarEscapes(t *testing.T) {
+	global := func(name string) (string, bool) {
+		if name == "HOME" {
+			return "/home/me", true
+		}
+		return "", false
+	}
+	replica := func(name string) (string, bool) {
+		value, ok := map[string]string{"NAME": "web.2", "INSTANCE": "2"}[name]
+		return value, ok
+	}
+	ports := func(ref string) (int, error) {
+		return 8080, nil
+	}
+
+	tests := []struct {
+		in, want string
+	}{
+		{in: "${HOME}/${NAME}-${INSTANCE}", want: "/home/me/web.2-2"},
+		{in: "$${HOME} $${NAME} $${INSTANCE}", want: "${HOME} ${NAME} ${INSTANCE}"},
+		{in: "${ports.db.pg} $${ports.db.pg}", want: "8080 ${ports.db.pg}"},
+	}
+	for _, tt := range tests {
+		s, err := expandVars(tt.in, global)
+		if err != nil {
+			t.Fatalf("expandVars(%q): %v", tt.in, err)
+		}
+		s = expandDeferredVars(s, replica)
+		if s, err = expandPortRefs(s, ports); err != nil {
+			t.Fatalf("expandPortRefs(%q): %v", tt.in, err)
+		}
+		if s != tt.want {
+			t.Errorf("%q expanded to %q, want %q", tt.in, s, tt.want)
+		}
+	}
+}
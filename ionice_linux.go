@@ -0,0 +1,16 @@
+package main
+
+import "syscall"
+
+const ioniceSupported = true
+
+const ioprioWhoPgrp = 2
+
+// setIOPriority sets the I/O scheduling priority of a process group.
+func setIOPriority(pgid, prio int) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoPgrp, uintptr(pgid), uintptr(prio))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+const ioniceSupported = false
+
+func setIOPriority(pgid, prio int) error {
+	return errors.New("ionice isn't supported on this platform")
+}
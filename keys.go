@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Actions that can be bound to keys with the "keybindings" setting.
+const (
+	ActionRestart     = "restart"
+	ActionStop        = "stop"
+	ActionStart       = "start"
+	ActionScaleUp     = "scale_up"
+	ActionScaleDown   = "scale_down"
+	ActionToggleGroup = "toggle_group"
+	ActionToggleMouse = "toggle_mouse"
+	ActionQuit        = "quit"
+)
+
+var defaultKeybindings = map[string][]string{
+	ActionRestart:     {"space"},
+	ActionStop:        {"s"},
+	ActionStart:       {"S", "Enter"},
+	ActionScaleUp:     {"+"},
+	ActionScaleDown:   {"-"},
+	ActionToggleGroup: {"Enter"},
+	ActionToggleMouse: {"m"},
+	ActionQuit:        {"Esc", "Ctrl-C"},
+}
+
+type keyBinding struct {
+	key tcell.Key
+	r   rune
+}
+
+// Keymap maps actions to the keys that trigger them.
+type Keymap map[string][]keyBinding
+
+// parseKey accepts a single character, "space", or a tcell key name such as
+// "Enter", "Esc", "Tab", "F5" or "Ctrl-R" (case insensitive).
+func parseKey(name string) (keyBinding, error) {
+	if runes := []rune(name); len(runes) == 1 {
+		return keyBinding{key: tcell.KeyRune, r: runes[0]}, nil
+	}
+	if strings.EqualFold(name, "space") {
+		return keyBinding{key: tcell.KeyRune, r: ' '}, nil
+	}
+	for key, keyName := range tcell.KeyNames {
+		if strings.EqualFold(name, keyName) {
+			return keyBinding{key: key}, nil
+		}
+	}
+	return keyBinding{}, fmt.Errorf("unknown key %q", name)
+}
+
+// NewKeymap builds the default keymap with the configured overrides, each of
+// which replaces every default key of its action.
+func NewKeymap(overrides map[string]string) (Keymap, error) {
+	keymap := make(Keymap, len(defaultKeybindings))
+	for action, names := range defaultKeybindings {
+		for _, name := range names {
+			binding, _ := parseKey(name)
+			keymap[action] = append(keymap[action], binding)
+		}
+	}
+
+	for action, name := range overrides {
+		if _, ok := defaultKeybindings[action]; !ok {
+			actions := make([]string, 0, len(defaultKeybindings))
+			for known := range defaultKeybindings {
+				actions = append(actions, known)
+			}
+			sort.Strings(actions)
+			return nil, fmt.Errorf("keybindings: unknown action %q (expected one of %s)", action, strings.Join(actions, ", "))
+		}
+		binding, err := parseKey(name)
+		if err != nil {
+			return nil, fmt.Errorf("keybindings: %s: %w", action, err)
+		}
+		keymap[action] = []keyBinding{binding}
+	}
+	return keymap, nil
+}
+
+// Matches reports whether the event triggers the action.
+func (k Keymap) Matches(action string, event *tcell.EventKey) bool {
+	for _, binding := range k[action] {
+		if event.Key() != binding.key {
+			continue
+		}
+		if binding.key != tcell.KeyRune || event.Rune() == binding.r {
+			return true
+		}
+	}
+	return false
+}
+
+// Label describes the keys of an action for the hotkey help.
+func (k Keymap) Label(action string) string {
+	labels := make([]string, 0, len(k[action]))
+	for _, binding := range k[action] {
+		switch {
+		case binding.key == tcell.KeyRune && binding.r == ' ':
+			labels = append(labels, "<space>")
+		case binding.key == tcell.KeyRune:
+			labels = append(labels, fmt.Sprintf("'%c'", binding.r))
+		default:
+			labels = append(labels, "<"+strings.ToLower(tcell.KeyNames[binding.key])+">")
+		}
+	}
+	return strings.Join(labels, "/")
+}
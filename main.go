@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -14,55 +15,114 @@ import (
 var (
 	Version            = "dev"
 	SigKillGracePeriod = 10 * time.Second
+	ProcessScrollback  = 2500
+	LogScrollback      = 1000
 )
 
 func usage() {
-	fmt.Println(`usage: gopm3
+	fmt.Println(`usage: gopm3 [flags]
+       gopm3 [flags] config print
 
-  -h/--help:    show this
-  -v/--version: show version
-  -c/--config:  pass explicit config path (otherwise assumes config exists in the same directory)`)
+  -h/--help:      show this
+  -v/--version:   show version
+  -c/--config:    pass explicit config path (otherwise assumes config exists in the same directory)
+  -s/--state-dir: directory for logs, pid and lock files (also $GOPM3_STATE_DIR, defaults to a per-project dir in ~/.gopm3)
+
+  config print:   print the config after includes and gopm3.config.local.json are merged`)
 }
 
-func argv() string {
+// argv returns the config path, the state dir flag, if given, and the
+// subcommand, if any.
+func argv() (string, string, []string) {
 	// Assume implicit config path
-	if len(os.Args) == 1 {
-		return "./gopm3.config.json"
-	}
+	cfgPath := "./gopm3.config.json"
+	stateDir := ""
+	var command []string
 
-	if len(os.Args) < 2 {
+	args := os.Args[1:]
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-h", "--help":
+			usage()
+			os.Exit(0)
+		case "-v", "--version":
+			fmt.Println(Version)
+			os.Exit(0)
+		case "-c", "--config", "-s", "--state-dir":
+			if i+1 >= len(args) {
+				usage()
+				os.Exit(1)
+			}
+			i++
+			if arg == "-c" || arg == "--config" {
+				cfgPath = args[i]
+			} else {
+				stateDir = args[i]
+			}
+		default:
+			if strings.HasPrefix(arg, "-") {
+				usage()
+				os.Exit(1)
+			}
+			command = append(command, arg)
+		}
+	}
+	if len(command) > 0 && (len(command) != 2 || command[0] != "config" || command[1] != "print") {
 		usage()
 		os.Exit(1)
 	}
+	return cfgPath, stateDir, command
+}
 
-	arg := os.Args[1]
-	if arg == "-h" || arg == "--help" {
-		usage()
-		os.Exit(0)
+// logsTitle describes the log pane of a process, along with its ports and
+// resource usage.
+func logsTitle(pm3 *ProcessManager, index int) string {
+	title := fmt.Sprintf(" Logs (merged stdout/stderr) (also available in %s/) ", displayStateDir())
+	if ports := pm3.process(index).portsSummary(); ports != "" {
+		title += fmt.Sprintf("[yellow]ports %s[white] ", ports)
+	}
+	if usage := pm3.ResourceUsage(index); usage != "" {
+		title += fmt.Sprintf("[yellow]%s[white] ", usage)
+	}
+	return title
+}
+
+func main() {
+	cfgPath, stateDirFlag, command := argv()
+
+	// Config parsing
+	cfg, err := loadConfig(cfgPath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	if arg == "-v" || arg == "--version" {
-		fmt.Println(Version)
-		os.Exit(0)
+	keymap, err := NewKeymap(cfg.Settings.Keybindings)
+	if err == nil {
+		err = cfg.Settings.apply()
 	}
-	if arg == "-v" || arg == "--version" {
-		fmt.Println(Version)
-		os.Exit(0)
+	if err != nil {
+		fmt.Printf("%s: %v\n", cfgPath, err)
+		os.Exit(1)
 	}
-	if arg == "-c" || arg == "--config" {
-		if len(os.Args) != 3 {
-			usage()
+
+	StateDir = resolveStateDir(stateDirFlag, cfg.Settings.StateDir, cfgPath)
+	if err := cfg.interpolate(cfgPath, StateDir); err != nil {
+		fmt.Printf("%s:\n%v\n", cfgPath, err)
+		os.Exit(1)
+	}
+	if len(command) > 0 {
+		if err := printConfig(os.Stdout, cfg); err != nil {
+			fmt.Println(err)
 			os.Exit(1)
 		}
-		return os.Args[2]
+		return
 	}
-
-	usage()
-	os.Exit(1)
-	return "unreachable :)"
-}
-
-func main() {
-	cfgPath := argv()
+	lockFile, err := lockStateDir(StateDir)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer lockFile.Close()
 
 	tui := tview.NewApplication()
 	redrawScheduler := NewRedrawScheduler(tui, 20*time.Millisecond)
@@ -72,42 +132,50 @@ func main() {
 
 	// Top boxes
 	logPages := tview.NewFlex()
-	logPages.SetBorder(true).SetTitle(" Logs (merged stdout/stderr) (also available in ~/.gopm3/) ")
+	logPages.SetBorder(true)
 	processList := tview.NewList().ShowSecondaryText(false)
 	processList.SetBorder(true)
 	processList.SetTitle("  Processes  ")
+	cfg.Settings.Theme.applySelected(processList)
 	topFlex := tview.NewFlex().AddItem(processList, 0, 1, true).AddItem(logPages, 0, 4, false)
 
 	// Bottom boxes
 	bottomFlex := tview.NewFlex()
 	bottomFlex.SetBorder(true)
-	bottomFlex.SetTitle(" gopm3 logs, hotkeys :: [yellow]<space>[white]: restart process, [yellow]'m'[white]: toggle mouse mode, [yellow]'s'[white]: stop process, [yellow]'esc'[white]: exit ")
+	bottomFlex.SetTitle(fmt.Sprintf(" gopm3 logs, hotkeys :: [yellow]%s[white]: restart process, [yellow]%s[white]: toggle mouse mode, [yellow]%s[white]: stop process, [yellow]%s[white]: start process, [yellow]%s/%s[white]: scale replicas, [yellow]%s[white]: collapse group, [yellow]%s[white]: exit ",
+		keymap.Label(ActionRestart), keymap.Label(ActionToggleMouse), keymap.Label(ActionStop), keymap.Label(ActionStart),
+		keymap.Label(ActionScaleUp), keymap.Label(ActionScaleDown), keymap.Label(ActionToggleGroup), keymap.Label(ActionQuit)))
 
 	// Merge all the things!
 	rootFlex := tview.NewFlex().SetDirection(tview.FlexRow)
 	rootFlex.AddItem(topFlex, 0, 4, true).AddItem(bottomFlex, 0, 1, false)
 	tui.SetRoot(rootFlex, true)
 
-	// Config parsing
-	processes := setupProcesses(cfgPath, redrawScheduler.Request)
-	for _, process := range processes {
-		processList.AddItem(process.cfg.Name, "", 0, func() {})
+	processes := setupProcesses(cfg.Processes, redrawScheduler.Request)
+	if len(processes) == 0 {
+		fmt.Println("No processes to run: the config has no enabled processes")
+		os.Exit(1)
 	}
+	if err := setupPorts(processes); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	groupedList := NewProcessList(processList, processes)
 
 	// Main entrypoint
 	pmLogs := tview.NewTextView().
 		SetDynamicColors(false).
 		SetScrollable(true).
-		SetMaxLines(1000).
+		SetMaxLines(LogScrollback).
 		SetChangedFunc(redrawScheduler.Request)
 	pmLogs.ScrollToEnd()
 	bottomFlex.AddItem(pmLogs, 0, 1, false)
-	pm3 := NewProcessManager(processes, pmLogs, processList, len(processes))
+	pm3 := NewProcessManager(processes, cfg.Settings, pmLogs, groupedList, len(processes), redrawScheduler.Request)
 	go func() {
 		pm3.Start()
 	}()
 
-	for _, process := range processes {
+	setupLogPane := func(process *Process) {
 		process.textView.ScrollToEnd()
 		processLogPane := process.textView
 		processLogPane.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
@@ -128,9 +196,12 @@ func main() {
 			return event
 		})
 	}
+	for _, process := range processes {
+		setupLogPane(process)
+	}
 
 	rootFlex.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-		if event.Rune() == 'm' {
+		if keymap.Matches(ActionToggleMouse, event) {
 			mouseState = !mouseState
 			tui.EnableMouse(mouseState)
 			pm3.Log("Mouse State: %v\n", mouseState)
@@ -139,31 +210,73 @@ func main() {
 	})
 
 	// Swap log views based on highlighted process list
-	processList.SetChangedFunc(func(i int, processName, secondary string, hotkey rune) {
+	groupedList.SetProcessChangedFunc(func(i int) {
 		logPages.Clear()
-		logPages.AddItem(processes[i].textView, 0, 1, false)
+		logPages.AddItem(pm3.process(i).textView, 0, 1, false)
+		logPages.SetTitle(logsTitle(pm3, i))
 	})
-	logPages.AddItem(processes[0].textView, 0, 1, false)
+	// Grouped processes are listed after the ungrouped ones, so the first row
+	// isn't necessarily processes[0]. A group header shows its first member.
+	group, first := groupedList.Selected()
+	if first < 0 {
+		first = groupedList.Members(group)[0]
+	}
+	logPages.AddItem(processes[first].textView, 0, 1, false)
+	logPages.SetTitle(logsTitle(pm3, first))
+
+	// Keep the resource usage in the title current.
+	if pm3.cgroups != nil {
+		go func() {
+			for range time.Tick(2 * time.Second) {
+				tui.QueueUpdateDraw(func() {
+					if _, index := groupedList.Selected(); index >= 0 {
+						logPages.SetTitle(logsTitle(pm3, index))
+					}
+				})
+			}
+		}()
+	}
 
 	// Support <space> for restarting individual processes
 	processList.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-		index := processList.GetCurrentItem()
-		processName := pm3.processes[index].cfg.Name
-
-		if event.Key() == tcell.KeyRune && event.Rune() == ' ' {
-			processList.SetItemText(index, fmt.Sprintf("[yellow](restarting)[white] %s", processName), "")
-			pm3.Log("Restarting process '%s'\n", processName)
-			pm3.mu.Lock()
-			pm3.processes[index].manualAction = ManualRestart
-			pm3.mu.Unlock()
-			go pm3.StopProcess(index, true)
-		} else if event.Rune() == 's' {
-			processList.SetItemText(index, fmt.Sprintf("[yellow](stopping)[white] %s", processName), "")
-			pm3.Log("Stopping process '%s'\n", pm3.processes[index].cfg.Name)
-			pm3.mu.Lock()
-			pm3.processes[index].manualAction = ManualStop
-			pm3.mu.Unlock()
-			go pm3.StopProcess(index, false)
+		group, index := groupedList.Selected()
+
+		// Group headers act on every member of the group.
+		if index < 0 {
+			if group == "" {
+				return event
+			}
+			if keymap.Matches(ActionToggleGroup, event) {
+				groupedList.ToggleGroup(group)
+				return nil
+			} else if keymap.Matches(ActionRestart, event) {
+				pm3.RestartGroup(group)
+				return nil
+			} else if keymap.Matches(ActionStop, event) {
+				pm3.StopGroup(group)
+				return nil
+			} else if keymap.Matches(ActionStart, event) {
+				pm3.StartGroup(group)
+				return nil
+			}
+			return event
+		}
+
+		if keymap.Matches(ActionRestart, event) {
+			pm3.RestartProcess(index)
+		} else if keymap.Matches(ActionStop, event) {
+			pm3.ManualStopProcess(index)
+			return nil
+		} else if keymap.Matches(ActionStart, event) {
+			pm3.StartProcess(index)
+			return nil
+		} else if keymap.Matches(ActionScaleUp, event) {
+			if process := pm3.ScaleUp(index); process != nil {
+				setupLogPane(process)
+			}
+			return nil
+		} else if keymap.Matches(ActionScaleDown, event) {
+			pm3.ScaleDown(index)
 			return nil
 		} else if event.Key() == tcell.KeyLeft || event.Rune() == 'h' {
 			tui.SetFocus(logPages.GetItem(0))
@@ -177,7 +290,7 @@ func main() {
 
 	// Kill with both ESC or Ctrl+c
 	tui.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-		if event.Key() == tcell.KeyEsc || event.Key() == tcell.KeyCtrlC {
+		if keymap.Matches(ActionQuit, event) {
 			go pm3.Stop(syscall.SIGTERM)
 			return nil
 		}
@@ -201,4 +314,7 @@ func main() {
 	<-pm3.exitChannel
 	tui.Stop()
 	fmt.Println("Bye Bye!")
+	if code := pm3.ExitCode(); code != 0 {
+		os.Exit(code)
+	}
 }
@@ -8,7 +8,6 @@ import (
 	"log"
 	"os"
 	"os/exec"
-	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -23,66 +22,100 @@ const (
 	dockerDetectPoll    = 200 * time.Millisecond
 )
 
-type ManualAction int
-
-const (
-	ManualNoop ManualAction = iota
-	ManualRestart
-	ManualStop
-)
-
 // TODO: all these arrays are loosely coupled by index.
 type ProcessManager struct {
 	processes      []*Process
 	runningCmds    []*exec.Cmd
 	exitChannel    chan bool
+	exitCode       int
+	done           chan struct{}
+	swept          chan struct{}
 	wg             sync.WaitGroup
+	dockerStops    sync.WaitGroup
 	mu             sync.Mutex
 	logs           *tview.TextView
 	logFile        *os.File
 	shuttingDown   bool
 	stopOnce       sync.Once
-	tuiProcessList *tview.List
+	tuiProcessList *ProcessList
 	disableLogs    bool
+	onLogsChanged  func()
+	settings       Settings
+	cgroups        *cgroupManager
+	notifier       *notifier
 
 	// Serializes "new container diffing" so docker-managed starts don't race.
 	dockerStartMu sync.Mutex
+	docker        *DockerClient
 }
 
 type ProcessConfig struct {
-	Name            string   `json:"name"`
-	Command         string   `json:"command"`
-	Args            []string `json:"args"`
-	RestartDelay    int      `json:"restart_delay"`
-	DisableLogs     bool     `json:"disable_logs,omitempty"`
-	DockerManaged   bool     `json:"docker_managed,omitempty"`
-	UseProcessGroup bool     `json:"use_process_group,omitempty"`
-}
-
-func NewProcessManager(processes []*Process, logsPane *tview.TextView, processList *tview.List, processCount int) *ProcessManager {
-	homeDir := os.Getenv("HOME")
-	logDir := fmt.Sprintf("%s/.gopm3", homeDir)
-	logFileName := fmt.Sprintf("%s/%s.log", logDir, "gopm3")
+	Name            string            `json:"name"`
+	Command         string            `json:"command"`
+	Args            []string          `json:"args"`
+	RestartDelay    int               `json:"restart_delay"`
+	DisableLogs     bool              `json:"disable_logs,omitempty"`
+	DockerManaged   bool              `json:"docker_managed,omitempty"`
+	UseProcessGroup bool              `json:"use_process_group,omitempty"`
+	Schedule        string            `json:"schedule,omitempty"`
+	Overlap         string            `json:"overlap,omitempty"`
+	PreStart        string            `json:"pre_start,omitempty"`
+	PostStart       string            `json:"post_start,omitempty"`
+	PreStop         string            `json:"pre_stop,omitempty"`
+	PostStop        string            `json:"post_stop,omitempty"`
+	HookTimeout     int               `json:"hook_timeout,omitempty"`
+	Watch           *WatchConfig      `json:"watch,omitempty"`
+	Env             map[string]string `json:"env,omitempty"`
+	Instances       int               `json:"instances,omitempty"`
+	Groups          []string          `json:"groups,omitempty"`
+	Autostart       *bool             `json:"autostart,omitempty"`
+	ExitOnComplete  bool              `json:"exit_on_complete,omitempty"`
+	Required        bool              `json:"required,omitempty"`
+	Container       *ContainerConfig  `json:"container,omitempty"`
+	Compose         *ComposeConfig    `json:"compose,omitempty"`
+	Disabled        bool              `json:"disabled,omitempty"`
+	Cwd             string            `json:"cwd,omitempty"`
+	Ports           map[string]string `json:"ports,omitempty"`
+	Proxy           *ProxyConfig      `json:"proxy,omitempty"`
+	Shell           *ShellConfig      `json:"shell,omitempty"`
+	User            string            `json:"user,omitempty"`
+	Group           string            `json:"group,omitempty"`
+	Umask           string            `json:"umask,omitempty"`
+	Nice            int               `json:"nice,omitempty"`
+	IONice          string            `json:"ionice,omitempty"`
+	Notify          bool              `json:"notify,omitempty"`
+}
+
+func NewProcessManager(processes []*Process, settings Settings, logsPane *tview.TextView, processList *ProcessList, processCount int, onLogsChanged func()) *ProcessManager {
+	logFileName := statePath("gopm3.log")
 	logFile, err := os.Create(logFileName)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	disableLogs := false
-	if os.Getenv("GOPM3_DISABLE_LOGS") != "" {
+	if settings.DisableLogs || os.Getenv("GOPM3_DISABLE_LOGS") != "" {
 		disableLogs = true
 	}
 
-	return &ProcessManager{
+	pm3 := &ProcessManager{
 		processes:      processes,
 		runningCmds:    make([]*exec.Cmd, processCount),
 		exitChannel:    make(chan bool),
+		done:           make(chan struct{}),
+		swept:          make(chan struct{}),
 		logs:           logsPane,
 		logFile:        logFile,
 		shuttingDown:   false,
 		tuiProcessList: processList,
 		disableLogs:    disableLogs,
+		onLogsChanged:  onLogsChanged,
+		settings:       settings,
+		notifier:       newNotifier(settings.Notifications),
+		docker:         NewDockerClient(dockerSocketPath()),
 	}
+	pm3.setupCgroups()
+	return pm3
 }
 
 func (pm3 *ProcessManager) isShuttingDown() bool {
@@ -93,8 +126,11 @@ func (pm3 *ProcessManager) isShuttingDown() bool {
 
 func (pm3 *ProcessManager) beginShutdown() {
 	pm3.mu.Lock()
-	pm3.shuttingDown = true
-	pm3.mu.Unlock()
+	defer pm3.mu.Unlock()
+	if !pm3.shuttingDown {
+		pm3.shuttingDown = true
+		close(pm3.done)
+	}
 }
 
 func (pm3 *ProcessManager) setRunningCmd(index int, cmd *exec.Cmd) {
@@ -109,6 +145,20 @@ func (pm3 *ProcessManager) getRunningCmd(index int) *exec.Cmd {
 	return pm3.runningCmds[index]
 }
 
+// process returns the process at index. ScaleUp appends to pm3.processes, so
+// it's only read under the lock.
+func (pm3 *ProcessManager) process(index int) *Process {
+	pm3.mu.Lock()
+	defer pm3.mu.Unlock()
+	return pm3.processes[index]
+}
+
+func (pm3 *ProcessManager) snapshotProcesses() []*Process {
+	pm3.mu.Lock()
+	defer pm3.mu.Unlock()
+	return pm3.processes[:len(pm3.processes):len(pm3.processes)]
+}
+
 func (pm3 *ProcessManager) snapshotRunningCmds() []*exec.Cmd {
 	pm3.mu.Lock()
 	defer pm3.mu.Unlock()
@@ -118,20 +168,6 @@ func (pm3 *ProcessManager) snapshotRunningCmds() []*exec.Cmd {
 	return cmds
 }
 
-func (pm3 *ProcessManager) writeRestartDecision(index int, value bool) {
-	ch := pm3.processes[index].restartBlock
-
-	// Keep only the latest decision and never block shutdown on this channel.
-	select {
-	case <-ch:
-	default:
-	}
-	select {
-	case ch <- value:
-	default:
-	}
-}
-
 func isProcessDoneErr(err error) bool {
 	return err == nil || errors.Is(err, os.ErrProcessDone) || errors.Is(err, syscall.ESRCH)
 }
@@ -167,133 +203,53 @@ func sanitizeProcessName(name string) string {
 	return name
 }
 
-func dockerCIDFilePath(processName string) string {
-	homeDir := os.Getenv("HOME")
-	logDir := fmt.Sprintf("%s/.gopm3", homeDir)
-	return fmt.Sprintf("%s/%s.cid", logDir, sanitizeProcessName(processName))
-}
-
-func dockerOutputIndicatesGone(output string) bool {
-	output = strings.ToLower(output)
-	return strings.Contains(output, "no such container") ||
-		strings.Contains(output, "is not running")
-}
+func (pm3 *ProcessManager) setupCmd(process *Process, index int) *exec.Cmd {
+	_ = index
 
-func listDockerContainerIDs() (map[string]struct{}, error) {
-	out, err := exec.Command("docker", "ps", "-q", "--no-trunc").Output()
-	if err != nil {
-		return nil, err
+	command := process.cfg.Command
+	args := process.cfg.Args
+	env := process.cfg.Env
+	process.dockerCIDFile = ""
+	process.userCIDFile = ""
+	process.dockerLabelled = false
+	if process.cfg.Shell.enabled() {
+		command, args = process.cfg.Shell.wrap(command, args)
 	}
+	if process.cfg.DockerManaged {
+		process.dockerCIDFile = dockerCIDFilePath(process.cfg.Name)
+		_ = os.Remove(process.dockerCIDFile)
 
-	ids := make(map[string]struct{})
-	for _, line := range strings.Split(string(out), "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+		if process.cfg.Container != nil {
+			pm3.removeNamedContainer(process)
+			command = "docker"
+			args = containerRunArgs(process.cfg, process.dockerCIDFile)
+		} else if process.cfg.Compose != nil {
+			command = "docker"
+			args = composeUpArgs(process.cfg)
 		}
-		ids[line] = struct{}{}
-	}
-	return ids, nil
-}
 
-func latestDockerContainerID() (string, error) {
-	out, err := exec.Command("docker", "ps", "-q", "--no-trunc", "--latest").Output()
-	if err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(string(out)), nil
-}
-
-func findNewDockerContainerID(before map[string]struct{}, timeout time.Duration) (string, error) {
-	deadline := time.Now().Add(timeout)
-	for time.Now().Before(deadline) {
-		after, err := listDockerContainerIDs()
-		if err == nil {
-			for id := range after {
-				if before == nil {
-					return id, nil
-				}
-				if _, seen := before[id]; !seen {
-					return id, nil
-				}
-			}
+		process.dockerLabel = dockerProcessLabel + "=" + dockerLabelValue(process.cfg.Name)
+		args, process.userCIDFile, process.dockerLabelled = prepareDockerRun(command, args, process.dockerLabel)
+		// Compose labels the service's containers with its project and service.
+		process.dockerLabelled = process.dockerLabelled || process.cfg.Compose != nil
+		if process.userCIDFile != "" && process.userCIDFile != process.dockerCIDFile {
+			// docker run refuses to start if the cidfile already exists.
+			_ = os.Remove(process.userCIDFile)
 		}
-		time.Sleep(dockerDetectPoll)
-	}
-
-	// Conservative fallback when diffing doesn't find one.
-	return latestDockerContainerID()
-}
-
-func (pm3 *ProcessManager) captureDockerContainerID(process *Process, before map[string]struct{}) error {
-	if !process.cfg.DockerManaged || process.dockerCIDFile == "" {
-		return nil
-	}
-
-	containerID, err := findNewDockerContainerID(before, dockerDetectTimeout)
-	if err != nil {
-		return err
-	}
-	containerID = strings.TrimSpace(containerID)
-	if containerID == "" {
-		return os.ErrNotExist
-	}
-
-	return os.WriteFile(process.dockerCIDFile, []byte(containerID), 0644)
-}
-
-func (pm3 *ProcessManager) killDockerContainer(process *Process) error {
-	if !process.cfg.DockerManaged || process.dockerCIDFile == "" {
-		return nil
-	}
-
-	data, err := os.ReadFile(process.dockerCIDFile)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil
+		env = map[string]string{"GOPM3_DOCKER_LABEL": process.dockerLabel}
+		for k, v := range process.cfg.Env {
+			env[k] = v
 		}
-		return err
-	}
-
-	containerID := strings.TrimSpace(string(data))
-	if containerID == "" {
-		return nil
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), dockerKillTimeout)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "docker", "kill", containerID)
-	output, cmdErr := cmd.CombinedOutput()
-	if cmdErr == nil {
-		_ = os.Remove(process.dockerCIDFile)
-		return nil
-	}
-
-	outputText := strings.TrimSpace(string(output))
-	if dockerOutputIndicatesGone(outputText) {
-		_ = os.Remove(process.dockerCIDFile)
-		return nil
-	}
-	if ctx.Err() == context.DeadlineExceeded {
-		return fmt.Errorf("timed out waiting for docker kill")
-	}
-	if outputText == "" {
-		return cmdErr
 	}
-	return fmt.Errorf("%w (%s)", cmdErr, outputText)
-}
-
-func (pm3 *ProcessManager) setupCmd(process *Process, index int) *exec.Cmd {
-	_ = index
 
-	process.dockerCIDFile = ""
-	if process.cfg.DockerManaged {
-		process.dockerCIDFile = dockerCIDFilePath(process.cfg.Name)
-		_ = os.Remove(process.dockerCIDFile)
+	cmd := exec.Command(command, args...)
+	cmd.Dir = process.cfg.Cwd
+	if len(env) > 0 {
+		cmd.Env = os.Environ()
+		for k, v := range env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
 	}
-
-	cmd := exec.Command(process.cfg.Command, process.cfg.Args...)
 	if pm3.disableLogs || process.cfg.DisableLogs {
 		cmd.Stdout = process.logFile
 		cmd.Stderr = process.logFile
@@ -317,11 +273,12 @@ func (pm3 *ProcessManager) writePid(execCmd *exec.Cmd, name string) {
 		return
 	}
 
-	homeDir := os.Getenv("HOME")
-	gopm3Dir := fmt.Sprintf("%s/.gopm3", homeDir)
-	pidFilename := fmt.Sprintf("%s/%s.pid", gopm3Dir, name)
-	pid := []byte(strconv.Itoa(execCmd.Process.Pid))
-	if err := os.WriteFile(pidFilename, pid, 0644); err != nil {
+	// The start time lets a later session tell a leftover process from an
+	// unrelated one that reused the pid.
+	record := pidRecord{pid: execCmd.Process.Pid, owner: os.Getpid()}
+	record.startTime, _ = processStartTime(record.pid)
+	record.ownerStartTime, _ = processStartTime(record.owner)
+	if err := os.WriteFile(pidFilePath(name), []byte(record.String()), 0644); err != nil {
 		pm3.Log("Failed to write pidFile for %s %s\n", execCmd.Path, execCmd.Args)
 	}
 }
@@ -331,20 +288,34 @@ func (pm3 *ProcessManager) Log(format string, v ...any) {
 	fmt.Fprintf(writer, format, v...)
 }
 
-func (pm3 *ProcessManager) RunProcess(process *Process, index int) {
-	defer pm3.wg.Done()
+// execProcess starts a single run of the process, blocks until it exits and
+// reports its progress to the process supervisor.
+func (pm3 *ProcessManager) execProcess(process *Process, index int) {
+	if err := pm3.runHook(process, HookPreStart); err != nil {
+		pm3.post(index, eventStartFailed, errPreStartFailed)
+		return
+	}
+	// The process may have been stopped while the hook was running.
+	if pm3.isShuttingDown() || pm3.processState(index) == StateStopping {
+		pm3.post(index, eventStartFailed, errStartAborted)
+		return
+	}
+
 	cmd := pm3.setupCmd(process, index)
 	pm3.setRunningCmd(index, cmd)
-	pm3.tuiProcessList.SetItemText(index, process.cfg.Name, "")
 
+	// Without an injected label we fall back to diffing running containers,
+	// which only works if docker-managed starts don't overlap.
 	var (
 		dockerBefore map[string]struct{}
 		dockerLocked bool
 	)
-	if process.cfg.DockerManaged {
+	if process.cfg.DockerManaged && !process.dockerLabelled && process.userCIDFile == "" {
 		pm3.dockerStartMu.Lock()
 		dockerLocked = true
-		before, err := listDockerContainerIDs()
+		ctx, cancel := context.WithTimeout(context.Background(), dockerAPITimeout)
+		before, err := pm3.docker.ContainerIDs(ctx, nil)
+		cancel()
 		if err != nil {
 			pm3.Log("Could not snapshot docker containers for '%s': %v\n", process.cfg.Name, err)
 		} else {
@@ -352,146 +323,367 @@ func (pm3 *ProcessManager) RunProcess(process *Process, index int) {
 		}
 	}
 
-	pm3.Log("Starting process %s (%s %s)\n", process.cfg.Name, process.cfg.Command, process.cfg.Args)
-	startErr := cmd.Start()
+	pm3.Log("Starting process %s (%s %s)\n", process.cfg.Name, cmd.Args[0], cmd.Args[1:])
+	startErr := pm3.startCmd(process, cmd)
 	if startErr != nil {
-		pm3.processes[index].logFile.Write([]byte(startErr.Error()))
+		fmt.Fprintf(pm3.hookWriter(process), "---- failed to start: %v ----\n", startErr)
 		pm3.Log("Failed to start process '%s': %v\n", process.cfg.Name, startErr)
 		if dockerLocked {
 			pm3.dockerStartMu.Unlock()
 		}
+		pm3.post(index, eventExited, startErr)
+		return
 	}
+	pm3.post(index, eventStarted, nil)
 
 	// Write PID to file and, for docker-managed processes, resolve/write CID.
-	if startErr == nil {
-		pm3.writePid(cmd, process.cfg.Name)
-		if process.cfg.DockerManaged {
-			if err := pm3.captureDockerContainerID(process, dockerBefore); err != nil {
-				pm3.Log("Could not determine docker container ID for '%s': %v\n", process.cfg.Name, err)
-			}
-		}
-		if dockerLocked {
-			pm3.dockerStartMu.Unlock()
+	pm3.writePid(cmd, process.cfg.Name)
+	dockerCtx, stopDockerEvents := context.WithCancel(context.Background())
+	defer stopDockerEvents()
+	if process.cfg.DockerManaged {
+		if containerID, err := pm3.captureDockerContainerID(process, dockerBefore); err != nil {
+			pm3.Log("Could not determine docker container ID for '%s': %v\n", process.cfg.Name, err)
+		} else {
+			go pm3.watchDockerEvents(dockerCtx, process, containerID)
 		}
 	}
-
-	osProcess := cmd.Process
-	if startErr != nil || osProcess == nil {
-		pm3.Log("Process %s has exited unexpectedly\n", process.cfg.Name)
+	if dockerLocked {
+		pm3.dockerStartMu.Unlock()
+	}
+	if err := pm3.runHook(process, HookPostStart); err == nil {
+		pm3.post(index, eventReady, nil)
 	} else {
-		if err := cmd.Wait(); err != nil {
-			pm3.Log("Process '%s' has exited: %v\n", process.cfg.Name, err)
-		} else {
-			pm3.Log("Process '%s' has exited\n", process.cfg.Name)
-		}
+		pm3.notify(index, NotifyReadyFailed, fmt.Sprintf("post_start hook failed: %v", err))
 	}
 
-	processName := pm3.processes[index].cfg.Name
-	if !pm3.isShuttingDown() {
-		shuttingDown := false
-		pm3.mu.Lock()
-		manualAction := pm3.processes[index].manualAction
-		pm3.mu.Unlock()
+	waitErr := children.wait(cmd)
+	if waitErr != nil {
+		pm3.Log("Process '%s' has exited: %v\n", process.cfg.Name, waitErr)
+	} else {
+		pm3.Log("Process '%s' has exited\n", process.cfg.Name)
+	}
 
-		if manualAction != ManualNoop {
-			// This "halts" the process so that we have control over when/if a process is restarted.
-			// Hack: we use the boolean value to determine whether we're shutting down or not.
-			shuttingDown = <-pm3.processes[index].restartBlock
+	pm3.runHook(process, HookPostStop)
+	pm3.post(index, eventExited, waitErr)
+}
 
-			pm3.mu.Lock()
-			pm3.processes[index].manualAction = ManualNoop
-			pm3.mu.Unlock()
-		} else {
-			pm3.tuiProcessList.SetItemText(index, fmt.Sprintf("[yellow](restarting)[white] %s", processName), "")
+// launch starts the supervisor for a process, and the process itself unless
+// it's configured with `autostart: false`.
+func (pm3 *ProcessManager) launch(process *Process, index int) {
+	pm3.wg.Add(1)
+	go pm3.supervise(process, index)
 
-			// TODO: Triggering a stop during this period will cause one extra restart.
-			time.Sleep(time.Duration(pm3.processes[index].cfg.RestartDelay) * time.Millisecond)
-		}
-		if !shuttingDown && !pm3.isShuttingDown() {
-			pm3.Log("Restarting process '%s'\n", process.cfg.Name)
-			pm3.processes[index].textView.Write([]byte("====================================================\n"))
-			pm3.processes[index].textView.Write([]byte("==================== Restarting ====================\n"))
-			pm3.processes[index].textView.Write([]byte("====================================================\n"))
-			pm3.wg.Add(1)
-			pm3.RunProcess(process, index)
-		}
+	if process.cfg.Autostart == nil || *process.cfg.Autostart {
+		pm3.post(index, eventStart, nil)
 	}
-
-	pm3.tuiProcessList.SetItemText(index, fmt.Sprintf("[red](dead)[white] %s", processName), "")
 }
 
 func (pm3 *ProcessManager) Start() {
-	for i, process := range pm3.processes {
-		pm3.wg.Add(1)
-		go pm3.RunProcess(process, i)
+	pm3.cleanupOrphans()
+	pm3.setupSubreaper()
+	go pm3.serveProxy()
+	go pm3.trackDescendants()
+	for i, process := range pm3.snapshotProcesses() {
+		if process.cfg.Watch != nil {
+			go pm3.watchProcess(process, i)
+		}
+		pm3.launch(process, i)
 	}
+
+	// Supervisors only return after a shutdown.
 	pm3.wg.Wait()
+	pm3.dockerStops.Wait()
+	pm3.waitCgroups()
+	pm3.stopAdopted()
 	pm3.Log("No more subprocesses are running!\n")
-	for _, process := range pm3.processes {
+	if pm3.cgroups != nil {
+		pm3.cgroups.cleanup()
+	}
+	for _, process := range pm3.snapshotProcesses() {
 		process.Cleanup()
 	}
 	pm3.logFile.Close()
 	pm3.exitChannel <- true
 }
 
+// exitCodeOf maps a cmd.Wait error to a shell style exit code.
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code
+		}
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+	}
+	return 1
+}
+
+// ExitCode is the status gopm3 should exit with: the first non-zero code from
+// an exit_on_complete or required process, otherwise 0.
+func (pm3 *ProcessManager) ExitCode() int {
+	pm3.mu.Lock()
+	defer pm3.mu.Unlock()
+	return pm3.exitCode
+}
+
+// complete records the exit code of a process that ends the session and shuts
+// everything else down.
+func (pm3 *ProcessManager) complete(index int, code int) {
+	pm3.mu.Lock()
+	if pm3.exitCode == 0 {
+		pm3.exitCode = code
+	}
+	pm3.mu.Unlock()
+
+	go pm3.shutdown(fmt.Sprintf("Process '%s' completed with exit code %d", pm3.process(index).cfg.Name, code))
+}
+
 func (pm3 *ProcessManager) Stop(caughtSignal os.Signal) {
+	pm3.shutdown(fmt.Sprintf("Caught signal: %v", caughtSignal))
+}
+
+func (pm3 *ProcessManager) shutdown(reason string) {
 	pm3.stopOnce.Do(func() {
+		// Held until every container stop below is started, so Start can't
+		// miss them once the supervisors return.
+		pm3.dockerStops.Add(1)
 		pm3.beginShutdown()
-		pm3.Log("Caught signal: %v, sending SIGTERM to all and waiting %s before SIGKILL\n", caughtSignal, SigKillGracePeriod)
-
-		// Ensure manually stopped processes are unblocked and can finish.
-		for i := range pm3.processes {
-			pm3.writeRestartDecision(i, true)
-			pm3.tuiProcessList.SetItemText(i, fmt.Sprintf("[yellow](stopping)[white] %s", pm3.processes[i].cfg.Name), "")
-		}
+		pm3.Log("%s, sending SIGTERM to all and waiting %s before SIGKILL\n", reason, SigKillGracePeriod)
 
+		// Give every running process a chance to run its pre_stop hook, in parallel
+		// so one slow hook doesn't hold up the rest.
+		var hooks sync.WaitGroup
 		for i, cmd := range pm3.snapshotRunningCmds() {
-			if err := pm3.killDockerContainer(pm3.processes[i]); err != nil {
-				pm3.Log("Error killing docker container for '%s': %v\n", pm3.processes[i].cfg.Name, err)
+			if cmd == nil || cmd.Process == nil || cmd.ProcessState != nil {
+				continue
 			}
+			hooks.Add(1)
+			go func() {
+				defer hooks.Done()
+				pm3.runHook(pm3.process(i), HookPreStop)
+			}()
+		}
+		hooks.Wait()
+
+		// Containers get `docker stop` semantics, in parallel since each may take
+		// the whole stop timeout.
+		for _, process := range pm3.snapshotProcesses() {
+			pm3.dockerStops.Add(1)
+			go func() {
+				defer pm3.dockerStops.Done()
+				if err := pm3.stopDockerContainer(process); err != nil {
+					pm3.Log("Error stopping docker container for '%s': %v\n", process.cfg.Name, err)
+				}
+			}()
+		}
+		pm3.dockerStops.Done()
 
+		for i, cmd := range pm3.snapshotRunningCmds() {
 			// On global shutdown, target process groups first to include descendants.
 			if err := pm3.signalCmd(cmd, syscall.SIGTERM, true); err != nil {
 				if fallbackErr := pm3.signalCmd(cmd, syscall.SIGTERM, false); fallbackErr != nil {
-					pm3.Log("Error stopping process '%s': %v\n", pm3.processes[i].cfg.Name, fallbackErr)
+					pm3.Log("Error stopping process '%s': %v\n", pm3.process(i).cfg.Name, fallbackErr)
 				}
 			}
+			if pm3.cgroups != nil {
+				pm3.cgroups.signal(pm3.process(i).cfg.Name, syscall.SIGTERM)
+			}
+			pm3.signalDescendants(i, syscall.SIGTERM)
 		}
+		// Orphans adopted from any process (or hook) go too.
+		signalAdopted(syscall.SIGTERM)
 
 		// If a process can't clean up and terminate in time, force-kill it.
 		go func() {
 			time.Sleep(SigKillGracePeriod)
 			for i, cmd := range pm3.snapshotRunningCmds() {
-				if err := pm3.killDockerContainer(pm3.processes[i]); err != nil {
-					pm3.Log("Error killing docker container for '%s': %v\n", pm3.processes[i].cfg.Name, err)
+				if err := pm3.killDockerContainer(pm3.process(i)); err != nil {
+					pm3.Log("Error killing docker container for '%s': %v\n", pm3.process(i).cfg.Name, err)
 				}
 
 				if err := pm3.signalCmd(cmd, syscall.SIGKILL, true); err != nil {
 					if fallbackErr := pm3.signalCmd(cmd, syscall.SIGKILL, false); fallbackErr != nil {
-						pm3.Log("Error force-killing process '%s': %v\n", pm3.processes[i].cfg.Name, fallbackErr)
+						pm3.Log("Error force-killing process '%s': %v\n", pm3.process(i).cfg.Name, fallbackErr)
 					}
 				}
+				if pm3.cgroups != nil {
+					pm3.cgroups.kill(pm3.process(i).cfg.Name)
+				}
+				pm3.killSurvivors(i, pm3.descendants(i))
 			}
+			close(pm3.swept)
 		}()
 	})
 }
 
-func (pm3 *ProcessManager) StopProcess(index int, restart bool) {
-	cmd := pm3.getRunningCmd(index)
-	if err := pm3.killDockerContainer(pm3.processes[index]); err != nil {
-		pm3.Log("Error killing docker container for '%s': %v\n", pm3.processes[index].cfg.Name, err)
+// replicas returns the indexes of every process created from the same
+// `instances` config entry as the process at index.
+func (pm3 *ProcessManager) replicas(index int) []int {
+	name := pm3.process(index).replicaOf
+	if name == "" {
+		return nil
 	}
 
-	if pm3.processes[index].cfg.UseProcessGroup {
-		if err := pm3.signalCmd(cmd, syscall.SIGTERM, true); err != nil {
-			pm3.Log("Error stopping process '%s': %v\n", pm3.processes[index].cfg.Name, err)
+	var indexes []int
+	for i, process := range pm3.snapshotProcesses() {
+		if process.replicaOf == name {
+			indexes = append(indexes, i)
 		}
-	} else {
-		if err := pm3.signalCmd(cmd, syscall.SIGTERM, false); err != nil {
-			pm3.Log("Error stopping process '%s': %v\n", pm3.processes[index].cfg.Name, err)
+	}
+	return indexes
+}
+
+// ScaleUp adds a replica of the process at index. A previously scaled down
+// replica is started again before a new one is created, in which case the new
+// process is returned so the caller can hook it up to the TUI.
+func (pm3 *ProcessManager) ScaleUp(index int) *Process {
+	indexes := pm3.replicas(index)
+	if len(indexes) == 0 || pm3.isShuttingDown() {
+		return nil
+	}
+
+	for _, i := range indexes {
+		if pm3.processState(i) == StateStopped {
+			pm3.Log("Scaling up '%s'\n", pm3.process(i).cfg.Name)
+			pm3.StartProcess(i)
+			return nil
+		}
+	}
+
+	pm3.mu.Lock()
+	// Once shutdown has begun Start may be waiting on the supervisors, so no
+	// new one can be added.
+	if pm3.shuttingDown {
+		pm3.mu.Unlock()
+		return nil
+	}
+	last := pm3.processes[indexes[len(indexes)-1]]
+	process := newReplica(last.template, last.instance+1, pm3.onLogsChanged)
+	process.schedule = last.schedule
+	err := process.allocatePorts()
+	if err == nil {
+		err = resolvePortRefs(append(pm3.processes, process), process)
+	}
+	if err != nil {
+		pm3.Log("Ports of '%s' could not be set up: %v\n", process.cfg.Name, err)
+	}
+	newIndex := len(pm3.processes)
+	pm3.processes = append(pm3.processes, process)
+	pm3.runningCmds = append(pm3.runningCmds, nil)
+	pm3.wg.Add(1)
+	pm3.mu.Unlock()
+
+	pm3.Log("Scaling up '%s'\n", process.cfg.Name)
+	pm3.tuiProcessList.AddProcess(process)
+	if process.cfg.Watch != nil {
+		go pm3.watchProcess(process, newIndex)
+	}
+	go pm3.supervise(process, newIndex)
+	pm3.StartProcess(newIndex)
+	return process
+}
+
+// ScaleDown stops the highest numbered running replica of the process at
+// index, always leaving at least one running.
+func (pm3 *ProcessManager) ScaleDown(index int) {
+	var running []int
+	for _, i := range pm3.replicas(index) {
+		if pm3.processState(i) != StateStopped {
+			running = append(running, i)
 		}
 	}
-	if restart {
-		pm3.writeRestartDecision(index, false)
+	if len(running) <= 1 {
+		return
+	}
+
+	target := running[len(running)-1]
+	pm3.Log("Scaling down '%s'\n", pm3.process(target).cfg.Name)
+	pm3.ManualStopProcess(target)
+}
+
+// RestartProcess restarts the process, starting it if it isn't running.
+func (pm3 *ProcessManager) RestartProcess(index int) {
+	pm3.post(index, eventRestart, nil)
+}
+
+// ManualStopProcess stops the process and keeps it stopped until it is
+// restarted or started again.
+func (pm3 *ProcessManager) ManualStopProcess(index int) {
+	pm3.post(index, eventStop, nil)
+}
+
+// StartProcess starts a stopped process. It is a no-op for processes that
+// are already running.
+func (pm3 *ProcessManager) StartProcess(index int) {
+	pm3.post(index, eventStart, nil)
+}
+
+func (pm3 *ProcessManager) RestartGroup(group string) {
+	pm3.Log("Restarting group '%s'\n", group)
+	for _, index := range pm3.tuiProcessList.Members(group) {
+		pm3.RestartProcess(index)
 	}
 }
+
+func (pm3 *ProcessManager) StopGroup(group string) {
+	pm3.Log("Stopping group '%s'\n", group)
+	for _, index := range pm3.tuiProcessList.Members(group) {
+		pm3.ManualStopProcess(index)
+	}
+}
+
+func (pm3 *ProcessManager) StartGroup(group string) {
+	pm3.Log("Starting group '%s'\n", group)
+	for _, index := range pm3.tuiProcessList.Members(group) {
+		pm3.StartProcess(index)
+	}
+}
+
+// StopProcess asks the running command to terminate. The supervisor notices
+// once it has exited.
+func (pm3 *ProcessManager) StopProcess(index int) {
+	cmd := pm3.getRunningCmd(index)
+	if cmd != nil && cmd.Process != nil && cmd.ProcessState == nil {
+		pm3.runHook(pm3.process(index), HookPreStop)
+	}
+	if err := pm3.stopDockerContainer(pm3.process(index)); err != nil {
+		pm3.Log("Error stopping docker container for '%s': %v\n", pm3.process(index).cfg.Name, err)
+	}
+
+	name := pm3.process(index).cfg.Name
+	if err := pm3.signalCmd(cmd, syscall.SIGTERM, pm3.process(index).cfg.UseProcessGroup); err != nil {
+		pm3.Log("Error stopping process '%s': %v\n", name, err)
+	}
+	// The recorded tree has the descendants outside the process group, or all
+	// of them when the command doesn't get its own.
+	pm3.signalDescendants(index, syscall.SIGTERM)
+	// Everything in the cgroup gets the same grace period as the command.
+	var members []int
+	if pm3.cgroups != nil {
+		members = pm3.cgroups.pids(name)
+		pm3.cgroups.signal(name, syscall.SIGTERM)
+	}
+
+	// Whatever is left of the tree once the grace period is over gets killed.
+	// The tree is taken now, so a restart's new run is left alone.
+	descendants := pm3.descendants(index)
+	go func() {
+		time.Sleep(SigKillGracePeriod)
+		pm3.killSurvivors(index, descendants)
+		if pm3.cgroups == nil {
+			return
+		}
+		switch pm3.processState(index) {
+		case StateStarting, StateRunning, StateReady:
+			// A restart runs in the same cgroup, only the old members go.
+			pm3.cgroups.killMembers(name, members)
+		default:
+			pm3.cgroups.kill(name)
+		}
+	}()
+}
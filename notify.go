@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Events that can trigger a notification.
+const (
+	NotifyCrash       = "crash"
+	NotifyRestart     = "restart"
+	NotifyReady       = "ready"
+	NotifyReadyFailed = "ready_failed"
+	NotifyCrashLoop   = "crash_loop"
+)
+
+const (
+	defaultNotifyDebounce = 30 * time.Second
+	notifyTimeout         = 5 * time.Second
+)
+
+// NotificationSettings configure how processes with `notify` report events.
+type NotificationSettings struct {
+	Events   []string `json:"events,omitempty"`
+	Bell     bool     `json:"bell,omitempty"`
+	Desktop  bool     `json:"desktop,omitempty"`
+	Webhook  string   `json:"webhook,omitempty"`
+	Debounce int      `json:"debounce,omitempty"`
+}
+
+func (n NotificationSettings) validate() error {
+	for _, event := range n.Events {
+		switch event {
+		case NotifyCrash, NotifyRestart, NotifyReady, NotifyReadyFailed, NotifyCrashLoop:
+		default:
+			return fmt.Errorf("notifications: unknown event %q (expected crash, restart, ready, ready_failed or crash_loop)", event)
+		}
+	}
+	if n.Webhook != "" {
+		u, err := url.Parse(n.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("notifications: webhook must be an http(s) URL, got %q", n.Webhook)
+		}
+		// Notifications carry process output, which shouldn't leave the machine.
+		if !isLoopbackHost(u.Hostname()) {
+			return fmt.Errorf("notifications: webhook must be on localhost, 127.0.0.0/8 or ::1, got %q", u.Host)
+		}
+	}
+	return nil
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (n NotificationSettings) debounce() time.Duration {
+	if n.Debounce > 0 {
+		return time.Duration(n.Debounce) * time.Millisecond
+	}
+	return defaultNotifyDebounce
+}
+
+// notifier delivers notifications, dropping repeats of the same event for the
+// same process within the debounce window so a crash loop doesn't flood every
+// channel.
+type notifier struct {
+	settings NotificationSettings
+	events   map[string]bool
+	http     *http.Client
+
+	mu         sync.Mutex
+	last       map[string]time.Time
+	suppressed map[string]int
+
+	missingNotifySend sync.Once
+}
+
+func newNotifier(settings NotificationSettings) *notifier {
+	events := map[string]bool{NotifyCrash: true}
+	if len(settings.Events) > 0 {
+		events = make(map[string]bool, len(settings.Events))
+		for _, event := range settings.Events {
+			events[event] = true
+		}
+	}
+	return &notifier{
+		settings: settings,
+		events:   events,
+		http: &http.Client{
+			Timeout: notifyTimeout,
+			// A redirect could send the notification elsewhere.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		last:       make(map[string]time.Time),
+		suppressed: make(map[string]int),
+	}
+}
+
+type notification struct {
+	Process string    `json:"process"`
+	Event   string    `json:"event"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// notify reports event for the process at index, if it opted in.
+func (pm3 *ProcessManager) notify(index int, event, message string) {
+	process := pm3.process(index)
+	n := pm3.notifier
+	if !process.cfg.Notify || !n.events[event] {
+		return
+	}
+
+	key := process.cfg.Name + "/" + event
+	now := time.Now()
+	n.mu.Lock()
+	if last, ok := n.last[key]; ok && now.Sub(last) < n.settings.debounce() {
+		n.suppressed[key]++
+		n.mu.Unlock()
+		return
+	}
+	n.last[key] = now
+	if count := n.suppressed[key]; count > 0 {
+		message = fmt.Sprintf("%s (%d more not shown)", message, count)
+	}
+	delete(n.suppressed, key)
+	n.mu.Unlock()
+
+	go pm3.deliver(notification{Process: process.cfg.Name, Event: event, Message: message, Time: now})
+}
+
+func (pm3 *ProcessManager) deliver(note notification) {
+	n := pm3.notifier
+	if n.settings.Bell {
+		ringBell()
+	}
+	if n.settings.Desktop {
+		err := sendDesktopNotification(note)
+		if errors.Is(err, exec.ErrNotFound) {
+			n.missingNotifySend.Do(func() {
+				pm3.Log("Could not send desktop notifications: notify-send isn't installed\n")
+			})
+		} else if err != nil {
+			pm3.Log("Could not send desktop notification: %v\n", err)
+		}
+	}
+	if n.settings.Webhook != "" {
+		if err := n.postWebhook(note); err != nil {
+			pm3.Log("Could not send notification webhook: %v\n", err)
+		}
+	}
+}
+
+// ringBell rings the terminal bell. It goes straight to the terminal since the
+// TUI owns stdout.
+func ringBell() {
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	defer tty.Close()
+	tty.Write([]byte("\a"))
+}
+
+func sendDesktopNotification(note notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	urgency := "normal"
+	if note.Event == NotifyCrash || note.Event == NotifyReadyFailed || note.Event == NotifyCrashLoop {
+		urgency = "critical"
+	}
+	title := fmt.Sprintf("gopm3: %s %s", note.Process, note.Event)
+	cmd := exec.CommandContext(ctx, "notify-send", "--app-name=gopm3", "--urgency="+urgency, title, note.Message)
+	return children.run(cmd)
+}
+
+func (n *notifier) postWebhook(note notification) error {
+	body, err := json.Marshal(note)
+	if err != nil {
+		return err
+	}
+	resp, err := n.http.Post(n.settings.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", n.settings.Webhook, resp.Status)
+	}
+	return nil
+}
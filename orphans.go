@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// orphanKillGrace is how long processes left over from a previous session get
+// to exit after SIGTERM before they are killed.
+const orphanKillGrace = 5 * time.Second
+
+var errProcessCheckUnsupported = errors.New("process verification isn't supported on this platform")
+
+// pidRecord is the content of a <name>.pid file: the pid on the first line
+// (so `kill $(head -1 name.pid)` keeps working), followed by the process
+// start time and the pid and start time of the gopm3 that started it.
+type pidRecord struct {
+	pid            int
+	startTime      uint64
+	owner          int
+	ownerStartTime uint64
+}
+
+func pidFilePath(processName string) string {
+	return statePath(processName + ".pid")
+}
+
+func (r pidRecord) String() string {
+	return fmt.Sprintf("%d\n%d\n%d\n%d\n", r.pid, r.startTime, r.owner, r.ownerStartTime)
+}
+
+func readPidFile(path string) (pidRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return pidRecord{}, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return pidRecord{}, fmt.Errorf("%s: no start time recorded", path)
+	}
+
+	var record pidRecord
+	if record.pid, err = strconv.Atoi(fields[0]); err != nil {
+		return pidRecord{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if record.startTime, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+		return pidRecord{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if record.owner, err = strconv.Atoi(fields[2]); err != nil {
+		return pidRecord{}, fmt.Errorf("%s: %w", path, err)
+	}
+	// Files written before the owner's start time was recorded don't have it.
+	if len(fields) > 3 {
+		if record.ownerStartTime, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
+			return pidRecord{}, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return record, nil
+}
+
+// ownerRunning reports whether the gopm3 that wrote the record is still
+// running, rather than an unrelated process that reused its pid.
+func (r pidRecord) ownerRunning() bool {
+	if r.owner == os.Getpid() || syscall.Kill(r.owner, 0) != nil {
+		return false
+	}
+	startTime, err := processStartTime(r.owner)
+	if errors.Is(err, errProcessCheckUnsupported) || r.ownerStartTime == 0 {
+		return true
+	}
+	return err == nil && startTime == r.ownerStartTime
+}
+
+// expectedCommand is the command a run of the process is started with.
+func expectedCommand(process *Process) string {
+	if process.cfg.Container != nil || process.cfg.Compose != nil {
+		return "docker"
+	}
+	if process.cfg.Shell.enabled() {
+		return process.cfg.Shell.argv[0]
+	}
+	return process.cfg.Command
+}
+
+// runsCommand reports whether command shows up in cmdline. Scripts run with
+// an interpreter (npm is `node /usr/bin/npm`), and the umask wrapper execs the
+// full path, so any element is compared by base name.
+func runsCommand(cmdline []string, command string) bool {
+	base := filepath.Base(command)
+	for _, arg := range cmdline {
+		if filepath.Base(arg) == base {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanupOrphans stops processes and containers left running by a previous
+// gopm3 session that was killed before it could shut them down, so they
+// don't hold on to ports and files the new session needs.
+func (pm3 *ProcessManager) cleanupOrphans() {
+	var wg sync.WaitGroup
+	for _, process := range pm3.snapshotProcesses() {
+		record, err := readPidFile(pidFilePath(process.cfg.Name))
+		if err == nil && record.ownerRunning() {
+			pm3.Log("Not cleaning up after '%s', the gopm3 that started it (pid %d) is still running\n", process.cfg.Name, record.owner)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err == nil {
+				pm3.cleanupOrphanProcess(process, record)
+			}
+			pm3.cleanupOrphanContainer(process)
+		}()
+	}
+	wg.Wait()
+}
+
+func (pm3 *ProcessManager) cleanupOrphanProcess(process *Process, record pidRecord) {
+	_ = os.Remove(pidFilePath(process.cfg.Name))
+
+	startTime, err := processStartTime(record.pid)
+	switch {
+	case errors.Is(err, errProcessCheckUnsupported):
+		return
+	case err != nil:
+		// The leader is gone, but the rest of its process group can outlive it.
+		// Its pgid isn't reused while any member is alive, so they're ours.
+		if syscall.Kill(-record.pid, 0) != nil {
+			return
+		}
+	case startTime != record.startTime:
+		// The pid has been reused by an unrelated process.
+		return
+	default:
+		// The pid and start time already identify the process, this only
+		// guards against a bad pid file.
+		cmdline, err := processCmdline(record.pid)
+		if err != nil {
+			return
+		}
+		if !runsCommand(cmdline, expectedCommand(process)) {
+			pm3.Log("Not cleaning up pid %d of '%s', it runs %q rather than %q\n",
+				record.pid, process.cfg.Name, strings.Join(cmdline, " "), expectedCommand(process))
+			return
+		}
+	}
+
+	pm3.Log("Stopping leftover process group %d of '%s' from a previous session\n", record.pid, process.cfg.Name)
+	_ = syscall.Kill(-record.pid, syscall.SIGTERM)
+	deadline := time.Now().Add(orphanKillGrace)
+	for time.Now().Before(deadline) {
+		if syscall.Kill(-record.pid, 0) != nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	pm3.Log("Leftover process group %d of '%s' didn't exit, killing it\n", record.pid, process.cfg.Name)
+	_ = syscall.Kill(-record.pid, syscall.SIGKILL)
+}
+
+// cleanupOrphanContainer stops the container recorded in a leftover .cid file.
+// Compose services are left alone since `docker compose up` adopts them.
+func (pm3 *ProcessManager) cleanupOrphanContainer(process *Process) {
+	if !process.cfg.DockerManaged || process.cfg.Compose != nil {
+		return
+	}
+	cidFile := dockerCIDFilePath(process.cfg.Name)
+	containerID := readCIDFile(cidFile)
+	if containerID == "" {
+		return
+	}
+
+	pm3.Log("Stopping leftover container %.12s of '%s' from a previous session\n", containerID, process.cfg.Name)
+	process.dockerCIDFile = cidFile
+	if err := pm3.stopDockerContainer(process); err != nil {
+		pm3.Log("Could not stop leftover container of '%s': %v\n", process.cfg.Name, err)
+	}
+	_ = os.Remove(cidFile)
+}
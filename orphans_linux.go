@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// processStartTime returns the start time of pid in clock ticks since boot,
+// which together with the pid identifies a process across pid reuse.
+func processStartTime(pid int) (uint64, error) {
+	entry, err := readProcStat(pid)
+	return entry.startTime, err
+}
+
+func processCmdline(pid int) ([]string, error) {
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00"), nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+func processStartTime(pid int) (uint64, error) {
+	return 0, errProcessCheckUnsupported
+}
+
+func processCmdline(pid int) ([]string, error) {
+	return nil, errProcessCheckUnsupported
+}
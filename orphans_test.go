@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPidFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    pidRecord
+		wantErr bool
+	}{
+		{name: "current", content: "10\n200\n30\n400\n", want: pidRecord{pid: 10, startTime: 200, owner: 30, ownerStartTime: 400}},
+		{name: "without owner start time", content: "10\n200\n30\n", want: pidRecord{pid: 10, startTime: 200, owner: 30}},
+		{name: "pid only", content: "10\n", wantErr: true},
+		{name: "garbage", content: "10\nabc\n30\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".pid")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readPidFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readPidFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("readPidFile() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPidRecordOwnerRunning(t *testing.T) {
+	startTime, err := processStartTime(os.Getppid())
+	if err != nil {
+		t.Skip(err)
+	}
+	parent := pidRecord{owner: os.Getppid(), ownerStartTime: startTime}
+	if !parent.ownerRunning() {
+		t.Error("ownerRunning() = false for a running owner")
+	}
+	reused := pidRecord{owner: os.Getppid(), ownerStartTime: startTime + 1}
+	if reused.ownerRunning() {
+		t.Error("ownerRunning() = true for a pid reused by another process")
+	}
+	legacy := pidRecord{owner: os.Getppid()}
+	if !legacy.ownerRunning() {
+		t.Error("ownerRunning() = false for a running owner without a start time")
+	}
+}
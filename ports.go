@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// portAllocator hands out free TCP ports, never the same one twice in a
+// session, so a port isn't given away while its process is restarting.
+type portAllocator struct {
+	mu    sync.Mutex
+	given map[int]bool
+}
+
+var portPool = &portAllocator{given: make(map[int]bool)}
+
+func (a *portAllocator) allocate(count int) ([]int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Hold on to the listeners until every port is picked, so the kernel
+	// doesn't hand out the same port twice.
+	var listeners []net.Listener
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	ports := make([]int, 0, count)
+	for len(ports) < count {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("allocating port: %w", err)
+		}
+		listeners = append(listeners, listener)
+		port := listener.Addr().(*net.TCPAddr).Port
+		if a.given[port] {
+			continue
+		}
+		a.given[port] = true
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// portNames returns the names of the process's ports in a stable order.
+func (cfg ProcessConfig) portNames() []string {
+	names := make([]string, 0, len(cfg.Ports))
+	for name := range cfg.Ports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// allocatePorts picks a free port for each entry in the process's "ports" and
+// exports it through the env var the entry names.
+func (process *Process) allocatePorts() error {
+	if len(process.cfg.Ports) == 0 {
+		return nil
+	}
+	names := process.cfg.portNames()
+	allocated, err := portPool.allocate(len(names))
+	if err != nil {
+		return err
+	}
+
+	env := make(map[string]string, len(process.cfg.Env)+len(names))
+	for k, v := range process.cfg.Env {
+		env[k] = v
+	}
+	process.ports = make(map[string]int, len(names))
+	for i, name := range names {
+		process.ports[name] = allocated[i]
+		if envName := process.cfg.Ports[name]; envName != "" {
+			env[envName] = strconv.Itoa(allocated[i])
+		}
+	}
+	process.cfg.Env = env
+	return nil
+}
+
+// portsSummary describes the allocated ports, e.g. "http:41234 debug:41235".
+func (process *Process) portsSummary() string {
+	parts := make([]string, 0, len(process.ports))
+	for _, name := range process.cfg.portNames() {
+		if port, ok := process.ports[name]; ok {
+			parts = append(parts, fmt.Sprintf("%s:%d", name, port))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// lookupPort resolves "<process>.<port>" from ${ports.<process>.<port>} for
+// a reference made by process from. Within a replica, the name of the
+// replicated process refers to the replica's own ports.
+func lookupPort(processes []*Process, from *Process, ref string) (int, error) {
+	dot := strings.LastIndexByte(ref, '.')
+	if dot < 0 {
+		return 0, fmt.Errorf("${ports.%s}: expected ${ports.<process>.<port>}", ref)
+	}
+	name, portName := ref[:dot], ref[dot+1:]
+
+	target := from
+	if from.replicaOf != name {
+		target = nil
+		var replicas int
+		for _, process := range processes {
+			if process.cfg.Name == name {
+				target = process
+				break
+			}
+			if process.replicaOf == name {
+				replicas++
+			}
+		}
+		if target == nil && replicas > 0 {
+			return 0, fmt.Errorf("${ports.%s}: '%s' has replicas, refer to one like ${ports.%s.1.%s}", ref, name, name, portName)
+		}
+		if target == nil {
+			return 0, fmt.Errorf("${ports.%s}: unknown process '%s'", ref, name)
+		}
+	}
+
+	port, ok := target.ports[portName]
+	if !ok {
+		return 0, fmt.Errorf("${ports.%s}: '%s' has no port named '%s'", ref, name, portName)
+	}
+	return port, nil
+}
+
+// expandPortRefs replaces ${ports.<process>.<port>} references in s.
+func expandPortRefs(s string, lookup func(ref string) (int, error)) (string, error) {
+	const prefix = "${ports."
+	var b strings.Builder
+	for {
+		start := strings.Index(s, prefix)
+		if start < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		length := strings.IndexByte(s[start:], '}')
+		if length < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		// `$${ports.…}` was kept escaped for this pass, it's a literal.
+		if start > 0 && s[start-1] == '$' {
+			b.WriteString(s[:start-1])
+			b.WriteString(s[start : start+length+1])
+			s = s[start+length+1:]
+			continue
+		}
+		port, err := lookup(s[start+len(prefix) : start+length])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s[:start])
+		b.WriteString(strconv.Itoa(port))
+		s = s[start+length+1:]
+	}
+}
+
+// resolvePortRefs expands the port references in the command, args, env and
+// cwd of process.
+func resolvePortRefs(processes []*Process, process *Process) error {
+	lookup := func(ref string) (int, error) {
+		return lookupPort(processes, process, ref)
+	}
+	cfg := &process.cfg
+	var err error
+	if cfg.Command, err = expandPortRefs(cfg.Command, lookup); err != nil {
+		return err
+	}
+	if cfg.Cwd, err = expandPortRefs(cfg.Cwd, lookup); err != nil {
+		return err
+	}
+	args := make([]string, len(cfg.Args))
+	for i, arg := range cfg.Args {
+		if args[i], err = expandPortRefs(arg, lookup); err != nil {
+			return err
+		}
+	}
+	cfg.Args = args
+	env := make(map[string]string, len(cfg.Env))
+	for k, v := range cfg.Env {
+		if env[k], err = expandPortRefs(v, lookup); err != nil {
+			return err
+		}
+	}
+	cfg.Env = env
+	return nil
+}
+
+// setupPorts allocates the ports of every process, then resolves the port
+// references between them.
+func setupPorts(processes []*Process) error {
+	for _, process := range processes {
+		if err := process.allocatePorts(); err != nil {
+			return fmt.Errorf("process '%s': %w", process.cfg.Name, err)
+		}
+	}
+	for _, process := range processes {
+		if err := resolvePortRefs(processes, process); err != nil {
+			return fmt.Errorf("process '%s': %w", process.cfg.Name, err)
+		}
+		if process.cfg.Proxy != nil {
+			if _, err := process.proxyPort(); err != nil {
+				return fmt.Errorf("process '%s': %w", process.cfg.Name, err)
+			}
+		}
+	}
+	return nil
+}
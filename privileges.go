@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// credential resolves the user and group settings to switch to, or nil when
+// neither is set.
+func (cfg ProcessConfig) credential() (*syscall.Credential, error) {
+	if cfg.User == "" && cfg.Group == "" {
+		return nil, nil
+	}
+
+	cred := &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	var account *user.User
+	if cfg.User != "" {
+		var err error
+		if account, err = lookupUser(cfg.User); err != nil {
+			return nil, err
+		}
+		uid, _ := strconv.ParseUint(account.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(account.Gid, 10, 32)
+		cred.Uid, cred.Gid = uint32(uid), uint32(gid)
+	}
+	if cfg.Group != "" {
+		group, err := user.LookupGroup(cfg.Group)
+		if err != nil {
+			if group, err = user.LookupGroupId(cfg.Group); err != nil {
+				return nil, fmt.Errorf("unknown group '%s'", cfg.Group)
+			}
+		}
+		gid, _ := strconv.ParseUint(group.Gid, 10, 32)
+		cred.Gid = uint32(gid)
+	}
+
+	if os.Geteuid() != 0 {
+		if cred.Uid != uint32(os.Geteuid()) || cred.Gid != uint32(os.Getegid()) {
+			return nil, errors.New("running as another user or group needs gopm3 to run as root")
+		}
+		// Only root may change the supplementary groups.
+		cred.NoSetGroups = true
+		return cred, nil
+	}
+	if account != nil {
+		groupIDs, err := account.GroupIds()
+		if err == nil {
+			for _, id := range groupIDs {
+				gid, err := strconv.ParseUint(id, 10, 32)
+				if err == nil {
+					cred.Groups = append(cred.Groups, uint32(gid))
+				}
+			}
+		}
+	}
+	return cred, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	account, err := user.Lookup(name)
+	if err == nil {
+		return account, nil
+	}
+	if account, err = user.LookupId(name); err == nil {
+		return account, nil
+	}
+	return nil, fmt.Errorf("unknown user '%s'", name)
+}
+
+// umask parses the octal umask setting, or returns -1 when it isn't set.
+func (cfg ProcessConfig) umask() (int, error) {
+	if cfg.Umask == "" {
+		return -1, nil
+	}
+	mask, err := strconv.ParseUint(cfg.Umask, 8, 32)
+	if err != nil || mask > 0o777 {
+		return 0, fmt.Errorf("invalid umask %q, expected octal like \"022\"", cfg.Umask)
+	}
+	return int(mask), nil
+}
+
+// withUmask makes cmd set its umask through sh before it execs the command.
+// syscall.Umask would change it for all of gopm3 for the duration, including
+// other starts and the files gopm3 creates meanwhile.
+func withUmask(cmd *exec.Cmd, mask int) {
+	if cmd.Err != nil {
+		// Let Start report why the command can't run.
+		return
+	}
+	script := fmt.Sprintf(`umask %04o && exec "$@"`, mask)
+	cmd.Args = append([]string{"sh", "-c", script, "sh", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = "/bin/sh"
+}
+
+// ionice class names as accepted by ionice(1).
+const (
+	ioprioClassRealtime   = 1
+	ioprioClassBestEffort = 2
+	ioprioClassIdle       = 3
+)
+
+// ioPriority parses the ionice setting: "idle", "best-effort[:0-7]" or
+// "realtime[:0-7]". It returns 0 when it isn't set.
+func (cfg ProcessConfig) ioPriority() (int, error) {
+	if cfg.IONice == "" {
+		return 0, nil
+	}
+	class, level, hasLevel := strings.Cut(cfg.IONice, ":")
+	var prio int
+	switch class {
+	case "idle":
+		if hasLevel {
+			return 0, fmt.Errorf("invalid ionice %q, the idle class has no level", cfg.IONice)
+		}
+		return ioprioClassIdle << 13, nil
+	case "best-effort":
+		prio = ioprioClassBestEffort << 13
+	case "realtime":
+		if os.Geteuid() != 0 {
+			return 0, errors.New("the realtime ionice class needs gopm3 to run as root")
+		}
+		prio = ioprioClassRealtime << 13
+	default:
+		return 0, fmt.Errorf("invalid ionice %q, expected idle, best-effort[:level] or realtime[:level]", cfg.IONice)
+	}
+	if !hasLevel {
+		return prio | 4, nil
+	}
+	n, err := strconv.Atoi(level)
+	if err != nil || n < 0 || n > 7 {
+		return 0, fmt.Errorf("invalid ionice level %q, expected 0-7", level)
+	}
+	return prio | n, nil
+}
+
+// validatePrivileges checks the user, group, umask, nice and ionice settings
+// before anything is started.
+func (cfg ProcessConfig) validatePrivileges() error {
+	if _, err := cfg.credential(); err != nil {
+		return err
+	}
+	if _, err := cfg.umask(); err != nil {
+		return err
+	}
+	if cfg.Nice < -20 || cfg.Nice > 19 {
+		return fmt.Errorf("invalid nice %d, expected -20 to 19", cfg.Nice)
+	}
+	if cfg.Nice < 0 && os.Geteuid() != 0 {
+		return errors.New("a negative nice needs gopm3 to run as root")
+	}
+	if cfg.IONice != "" && !ioniceSupported {
+		return errors.New("ionice isn't supported on this platform")
+	}
+	_, err := cfg.ioPriority()
+	return err
+}
+
+// startCmd starts cmd in the process's cgroup with its umask, then applies its
+// nice and ionice settings to the new process group.
+func (pm3 *ProcessManager) startCmd(process *Process, cmd *exec.Cmd) error {
+	cred, err := process.cfg.credential()
+	if err != nil {
+		return err
+	}
+	if cred != nil {
+		cmd.SysProcAttr.Credential = cred
+	}
+
+	if pm3.cgroups != nil {
+		release, err := pm3.cgroups.attach(cmd, process.cfg.Name)
+		if err != nil {
+			pm3.Log("Could not create a cgroup for '%s': %v\n", process.cfg.Name, err)
+		} else {
+			defer release()
+		}
+	}
+
+	mask, err := process.cfg.umask()
+	if err != nil {
+		return err
+	}
+	if mask >= 0 {
+		withUmask(cmd, mask)
+	}
+	if err := children.start(cmd); err != nil {
+		if errors.Is(err, syscall.EPERM) && cred != nil {
+			return fmt.Errorf("%w (switching to user '%s' group '%s')", err, process.cfg.User, process.cfg.Group)
+		}
+		return err
+	}
+
+	// Commands run in their own process group, so the settings also reach
+	// anything they have already spawned.
+	pgid := cmd.Process.Pid
+	writer := pm3.hookWriter(process)
+	if process.cfg.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PGRP, pgid, process.cfg.Nice); err != nil {
+			fmt.Fprintf(writer, "---- could not set nice %d: %v ----\n", process.cfg.Nice, err)
+		}
+	}
+	if prio, _ := process.cfg.ioPriority(); prio != 0 {
+		if err := setIOPriority(pgid, prio); err != nil {
+			fmt.Fprintf(writer, "---- could not set ionice %s: %v ----\n", process.cfg.IONice, err)
+		}
+	}
+	return nil
+}
@@ -1,31 +1,59 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/rivo/tview"
 )
 
 type Process struct {
-	cfg          ProcessConfig
-	logFile      *os.File
-	textView     *tview.TextView
-	manualAction ManualAction
-	hasFocus     bool
+	cfg      ProcessConfig
+	logFile  *os.File
+	textView *tview.TextView
+	hasFocus bool
+
+	// Lifecycle state, owned by the process supervisor and guarded by the
+	// manager mutex.
+	state   ProcessState
+	nextRun time.Time
+
+	// Events for the supervisor, closed supervisorDone once it returns.
+	events         chan processEvent
+	supervisorDone chan struct{}
 
 	// Buffered writer for log output
 	bufferedWriter *BufferedWriter
 
-	// Docker run metadata used for reliable shutdown.
-	dockerCIDFile string
+	// Parsed from cfg.Schedule; nil for long-running processes.
+	schedule *Schedule
+
+	// Set for replicas of a config entry with `instances`. template is the
+	// unexpanded config used to create more replicas at runtime.
+	replicaOf string
+	instance  int
+	template  ProcessConfig
 
-	// Used to block the restarting of a process.
-	// The primary purpose is to enable manaual stop/starts.
-	restartBlock chan bool
+	// Docker run metadata used for reliable shutdown. dockerLabel identifies
+	// the container of the current run, dockerLabelled is set when it was
+	// injected into a `docker run` (or compose labels the container), and
+	// userCIDFile is a --cidfile passed in the process args.
+	dockerCIDFile  string
+	dockerLabel    string
+	dockerLabelled bool
+	userCIDFile    string
+
+	// Ports allocated for the entries of cfg.Ports, by name.
+	ports map[string]int
+
+	// Every descendant seen while the process was running, by pid.
+	treeMu sync.Mutex
+	tree   map[int]procEntry
 }
 
 func (p *Process) Cleanup() {
@@ -41,12 +69,7 @@ func (p *Process) Cleanup() {
 }
 
 func NewProcess(processConfig ProcessConfig, logsPane *tview.TextView) *Process {
-	homeDir := os.Getenv("HOME")
-	logDir := fmt.Sprintf("%s/.gopm3", homeDir)
-	if err := os.MkdirAll(logDir, os.ModePerm); err != nil {
-		panic(err)
-	}
-	logFileName := fmt.Sprintf("%s/%s.log", logDir, processConfig.Name)
+	logFileName := statePath(processConfig.Name + ".log")
 	logFile, err := os.Create(logFileName)
 	if err != nil {
 		log.Fatal(err)
@@ -57,33 +80,153 @@ func NewProcess(processConfig ProcessConfig, logsPane *tview.TextView) *Process
 		logFile:  logFile,
 		textView: logsPane,
 
-		// Buffered channel so that we don't block on send.
-		restartBlock: make(chan bool, 1),
+		events:         make(chan processEvent, 16),
+		supervisorDone: make(chan struct{}),
 	}
 }
 
-func setupProcesses(cfgPath string, onChanged func()) []*Process {
-	configFile, err := os.Open(cfgPath)
-	if err != nil {
-		fmt.Println("Missing config file: ./gopm3.config.json")
-		os.Exit(1)
+func newProcessLogsPane(onChanged func()) *tview.TextView {
+	return tview.NewTextView().
+		SetScrollable(true).
+		SetMaxLines(ProcessScrollback).
+		SetDynamicColors(true).
+		SetChangedFunc(onChanged)
+}
+
+// expandInstance returns the config for a single replica, substituting
+// ${NAME}, ${INSTANCE} (1-based) and ${PORT_OFFSET} (0-based) in the command,
+// args, env, cwd and container.
+func expandInstance(cfg ProcessConfig, instance int) ProcessConfig {
+	name := fmt.Sprintf("%s.%d", cfg.Name, instance)
+	expanded := replaceVars(cfg, map[string]string{
+		"NAME":        name,
+		"INSTANCE":    strconv.Itoa(instance),
+		"PORT_OFFSET": strconv.Itoa(instance - 1),
+	})
+	expanded.Name = name
+	return expanded
+}
+
+// replaceVars expands the deferred variables in vars in the command, args,
+// env, cwd and container.
+func replaceVars(cfg ProcessConfig, vars map[string]string) ProcessConfig {
+	expand := func(s string) string {
+		return expandDeferredVars(s, func(name string) (string, bool) {
+			value, ok := vars[name]
+			return value, ok
+		})
 	}
-	config, err := io.ReadAll(configFile)
-	if err != nil {
-		panic(err)
+	expanded := cfg
+	expanded.Command = expand(cfg.Command)
+	expanded.Cwd = expand(cfg.Cwd)
+	expanded.Args = make([]string, len(cfg.Args))
+	for i, arg := range cfg.Args {
+		expanded.Args[i] = expand(arg)
 	}
-	var cfgs []ProcessConfig
-	json.Unmarshal(config, &cfgs)
-	processes := make([]*Process, len(cfgs), len(cfgs))
-	for i, cfg := range cfgs {
-		textView := tview.NewTextView()
-		processLogsPane := textView.
-			SetScrollable(true).
-			SetMaxLines(2500).
-			SetDynamicColors(true).
-			SetChangedFunc(onChanged)
-		process := NewProcess(cfg, processLogsPane)
-		processes[i] = process
+	if cfg.Env != nil {
+		expanded.Env = make(map[string]string, len(cfg.Env))
+		for k, v := range cfg.Env {
+			expanded.Env[k] = expand(v)
+		}
+	}
+	if cfg.Container != nil {
+		container := *cfg.Container
+		container.Name = expand(container.Name)
+		container.Ports = make([]string, len(cfg.Container.Ports))
+		for i, port := range cfg.Container.Ports {
+			container.Ports[i] = expand(port)
+		}
+		container.Volumes = make([]string, len(cfg.Container.Volumes))
+		for i, volume := range cfg.Container.Volumes {
+			container.Volumes[i] = expand(volume)
+		}
+		container.Env = make(map[string]string, len(cfg.Container.Env))
+		for k, v := range cfg.Container.Env {
+			container.Env[k] = expand(v)
+		}
+		expanded.Container = &container
+	}
+	return expanded
+}
+
+// newReplica creates the process for one instance of a scaled config entry.
+func newReplica(template ProcessConfig, instance int, onChanged func()) *Process {
+	process := NewProcess(expandInstance(template, instance), newProcessLogsPane(onChanged))
+	process.replicaOf = template.Name
+	process.instance = instance
+	process.template = template
+	return process
+}
+
+func setupProcesses(cfgs []ProcessConfig, onChanged func()) []*Process {
+	var processes []*Process
+	for _, cfg := range cfgs {
+		if cfg.Container != nil {
+			if cfg.Container.Image == "" {
+				fmt.Printf("Process '%s': container needs an image\n", cfg.Name)
+				os.Exit(1)
+			}
+			if cfg.Instances > 0 && cfg.Container.Name != "" && !strings.Contains(cfg.Container.Name, "${NAME}") && !strings.Contains(cfg.Container.Name, "${INSTANCE}") {
+				fmt.Printf("Process '%s': replicas can't share a container name, use ${NAME} or ${INSTANCE} in it\n", cfg.Name)
+				os.Exit(1)
+			}
+			cfg.DockerManaged = true
+		}
+		if cfg.Compose != nil {
+			if cfg.Compose.File == "" || cfg.Compose.Service == "" {
+				fmt.Printf("Process '%s': compose needs a file and a service\n", cfg.Name)
+				os.Exit(1)
+			}
+			if cfg.Container != nil {
+				fmt.Printf("Process '%s': container and compose can't be used together\n", cfg.Name)
+				os.Exit(1)
+			}
+			cfg.DockerManaged = true
+		}
+
+		if cfg.Shell.enabled() {
+			if cfg.Container != nil || cfg.Compose != nil {
+				fmt.Printf("Process '%s': shell can't be used with container or compose\n", cfg.Name)
+				os.Exit(1)
+			}
+			// Signalling just the shell would leave the commands it runs behind.
+			cfg.UseProcessGroup = true
+		}
+
+		if err := cfg.validatePrivileges(); err != nil {
+			fmt.Printf("Process '%s': %v\n", cfg.Name, err)
+			os.Exit(1)
+		}
+
+		var schedule *Schedule
+		if cfg.Schedule != "" {
+			var err error
+			schedule, err = ParseSchedule(cfg.Schedule)
+			if err != nil {
+				fmt.Printf("Process '%s': %v\n", cfg.Name, err)
+				os.Exit(1)
+			}
+		}
+		switch cfg.Overlap {
+		case "", OverlapSkip, OverlapQueue, OverlapKill:
+		default:
+			fmt.Printf("Process '%s': unknown overlap policy %q (expected skip, queue or kill)\n", cfg.Name, cfg.Overlap)
+			os.Exit(1)
+		}
+
+		if cfg.Instances > 0 {
+			for instance := 1; instance <= cfg.Instances; instance++ {
+				process := newReplica(cfg, instance, onChanged)
+				process.schedule = schedule
+				processes = append(processes, process)
+			}
+			continue
+		}
+
+		cfg = replaceVars(cfg, map[string]string{"NAME": cfg.Name})
+		process := NewProcess(cfg, newProcessLogsPane(onChanged))
+		process.schedule = schedule
+		processes = append(processes, process)
 	}
 	return processes
 }
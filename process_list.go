@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/rivo/tview"
+)
+
+// processListRow is a single line in the process list: either a group header
+// (process == -1) or a process, which may appear under several groups.
+type processListRow struct {
+	group   string
+	process int
+}
+
+// ProcessList renders processes, grouped under collapsible headers, into a
+// tview.List and maps list rows back to process indexes.
+type ProcessList struct {
+	*tview.List
+
+	mu        sync.Mutex
+	labels    []string
+	groups    []string
+	members   map[string][]int
+	ungrouped []int
+	collapsed map[string]bool
+
+	// rows is only rebuilt on the UI goroutine, which is also where the
+	// changed callback runs, so it may be read there without holding mu.
+	rows       []processListRow
+	rebuilding bool
+	changed    func(index int)
+}
+
+func NewProcessList(list *tview.List, processes []*Process) *ProcessList {
+	pl := &ProcessList{
+		List:      list,
+		members:   make(map[string][]int),
+		collapsed: make(map[string]bool),
+	}
+	for _, process := range processes {
+		pl.addProcess(process)
+	}
+	pl.rebuild()
+	list.SetChangedFunc(func(row int, mainText, secondaryText string, shortcut rune) {
+		if pl.rebuilding || pl.changed == nil || row >= len(pl.rows) {
+			return
+		}
+		if index := pl.rows[row].process; index >= 0 {
+			pl.changed(index)
+		}
+	})
+	return pl
+}
+
+// SetProcessChangedFunc sets the handler called with the process index when
+// the highlighted row moves to a process.
+func (pl *ProcessList) SetProcessChangedFunc(handler func(index int)) {
+	pl.changed = handler
+}
+
+func (pl *ProcessList) addProcess(process *Process) {
+	index := len(pl.labels)
+	pl.labels = append(pl.labels, process.cfg.Name)
+	if len(process.cfg.Groups) == 0 {
+		pl.ungrouped = append(pl.ungrouped, index)
+		return
+	}
+	for _, group := range process.cfg.Groups {
+		if _, ok := pl.members[group]; !ok {
+			pl.groups = append(pl.groups, group)
+		}
+		pl.members[group] = append(pl.members[group], index)
+	}
+}
+
+// AddProcess appends a process created at runtime.
+func (pl *ProcessList) AddProcess(process *Process) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.addProcess(process)
+	pl.rebuild()
+}
+
+func (pl *ProcessList) headerText(group string) string {
+	arrow := "▼"
+	if pl.collapsed[group] {
+		arrow = "▶"
+	}
+	return fmt.Sprintf("[::b]%s %s (%d)", arrow, group, len(pl.members[group]))
+}
+
+func (pl *ProcessList) rebuild() {
+	current := pl.GetCurrentItem()
+	pl.rows = pl.rows[:0]
+	for _, index := range pl.ungrouped {
+		pl.rows = append(pl.rows, processListRow{process: index})
+	}
+	for _, group := range pl.groups {
+		pl.rows = append(pl.rows, processListRow{group: group, process: -1})
+		if pl.collapsed[group] {
+			continue
+		}
+		for _, index := range pl.members[group] {
+			pl.rows = append(pl.rows, processListRow{group: group, process: index})
+		}
+	}
+
+	pl.rebuilding = true
+	defer func() { pl.rebuilding = false }()
+	pl.Clear()
+	for _, row := range pl.rows {
+		if row.process < 0 {
+			pl.AddItem(pl.headerText(row.group), "", 0, nil)
+		} else if row.group != "" {
+			pl.AddItem("  "+pl.labels[row.process], "", 0, nil)
+		} else {
+			pl.AddItem(pl.labels[row.process], "", 0, nil)
+		}
+	}
+	if current < len(pl.rows) {
+		pl.SetCurrentItem(current)
+	}
+}
+
+// SetItemText sets the label of every row showing the process at index.
+func (pl *ProcessList) SetItemText(index int, text, secondary string) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	pl.labels[index] = text
+	for row, r := range pl.rows {
+		if r.process != index {
+			continue
+		}
+		if r.group != "" {
+			pl.List.SetItemText(row, "  "+text, secondary)
+		} else {
+			pl.List.SetItemText(row, text, secondary)
+		}
+	}
+}
+
+// Row returns the group and process index shown at a list row. The process
+// index is -1 for group headers.
+func (pl *ProcessList) Row(row int) (string, int) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if row < 0 || row >= len(pl.rows) {
+		return "", -1
+	}
+	return pl.rows[row].group, pl.rows[row].process
+}
+
+// Selected returns the group and process index of the highlighted row.
+func (pl *ProcessList) Selected() (string, int) {
+	return pl.Row(pl.GetCurrentItem())
+}
+
+// Members returns the process indexes belonging to a group.
+func (pl *ProcessList) Members(group string) []int {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	return append([]int(nil), pl.members[group]...)
+}
+
+// ToggleGroup collapses or expands a group header.
+func (pl *ProcessList) ToggleGroup(group string) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.collapsed[group] = !pl.collapsed[group]
+	pl.rebuild()
+}
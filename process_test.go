@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandInstanceContainer(t *testing.T) {
+	template := ProcessConfig{
+		Name: "db",
+		Container: &ContainerConfig{
+			Image:   "postgres:16",
+			Name:    "pg-${INSTANCE}",
+			Ports:   []string{"54${PORT_OFFSET}2:5432"},
+			Volumes: []string{"${NAME}:/var/lib/postgresql/data"},
+			Env:     map[string]string{"POSTGRES_DB": "app${INSTANCE}"},
+		},
+	}
+
+	cfg := expandInstance(template, 2)
+	want := &ContainerConfig{
+		Image:   "postgres:16",
+		Name:    "pg-2",
+		Ports:   []string{"5412:5432"},
+		Volumes: []string{"db.2:/var/lib/postgresql/data"},
+		Env:     map[string]string{"POSTGRES_DB": "app2"},
+	}
+	if !reflect.DeepEqual(cfg.Container, want) {
+		t.Errorf("container = %+v, want %+v", cfg.Container, want)
+	}
+	if template.Container.Name != "pg-${INSTANCE}" {
+		t.Errorf("template was modified: %+v", template.Container)
+	}
+}
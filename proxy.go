@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const defaultProxyListen = "127.0.0.1:8000"
+
+// ProxyConfig routes a hostname on the built-in reverse proxy to one of the
+// process's ports.
+type ProxyConfig struct {
+	// Port is the name of an entry in "ports" or a port number. It may be
+	// left out when the process has a single port.
+	Port string `json:"port,omitempty"`
+	// Host defaults to "<name>.localhost".
+	Host string `json:"host,omitempty"`
+	// StartOnRequest starts the process when a request comes in while it's
+	// stopped, e.g. for processes with "autostart": false.
+	StartOnRequest bool `json:"start_on_request,omitempty"`
+}
+
+func (process *Process) proxyHost() string {
+	if process.cfg.Proxy.Host != "" {
+		return strings.ToLower(process.cfg.Proxy.Host)
+	}
+	return strings.ToLower(process.cfg.Name) + ".localhost"
+}
+
+// proxyPort resolves the port requests for the process are sent to.
+func (process *Process) proxyPort() (int, error) {
+	name := process.cfg.Proxy.Port
+	if name == "" {
+		if len(process.ports) != 1 {
+			return 0, errors.New("proxy: set a port, the process doesn't have exactly one")
+		}
+		for _, port := range process.ports {
+			return port, nil
+		}
+	}
+	if port, ok := process.ports[name]; ok {
+		return port, nil
+	}
+	if port, err := strconv.Atoi(name); err == nil {
+		return port, nil
+	}
+	return 0, fmt.Errorf("proxy: no port named '%s'", name)
+}
+
+// proxyRoutes returns the indexes of the processes serving host. Replicas
+// are also served under the hostname of the process they replicate.
+func (pm3 *ProcessManager) proxyRoutes(host string) []int {
+	var routes []int
+	for i, process := range pm3.snapshotProcesses() {
+		if process.cfg.Proxy == nil {
+			continue
+		}
+		if process.proxyHost() == host {
+			return []int{i}
+		}
+		if process.replicaOf != "" && process.cfg.Proxy.Host == "" && strings.ToLower(process.replicaOf)+".localhost" == host {
+			routes = append(routes, i)
+		}
+	}
+	return routes
+}
+
+// proxyHandler routes requests by hostname to the processes' ports.
+type proxyHandler struct {
+	pm3  *ProcessManager
+	next atomic.Uint64
+}
+
+func (h *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pm3 := h.pm3
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.ToLower(host)
+
+	routes := pm3.proxyRoutes(host)
+	if len(routes) == 0 {
+		writeProxyPage(w, http.StatusNotFound, "Unknown host", fmt.Sprintf("No process is served at %s.", host), false)
+		return
+	}
+
+	// Spread requests over the replicas that are up.
+	var up []int
+	for _, index := range routes {
+		if state := pm3.processState(index); state == StateRunning || state == StateReady {
+			up = append(up, index)
+		}
+	}
+	if len(up) == 0 {
+		index := routes[0]
+		process := pm3.process(index)
+		state := pm3.processState(index)
+		// A crash loop waits for a manual restart, a reloading page shouldn't do it.
+		if process.cfg.Proxy.StartOnRequest && !state.active() && state != StateBackoff && state != StateCrashLoop {
+			pm3.Log("Starting process '%s' for a request to %s\n", process.cfg.Name, host)
+			pm3.StartProcess(index)
+			state = StateStarting
+		}
+		writeProxyPage(w, http.StatusServiceUnavailable, process.cfg.Name+" is "+proxyStateText(state),
+			fmt.Sprintf("%s is %s, this page will reload when it's back.", process.cfg.Name, proxyStateText(state)), true)
+		return
+	}
+
+	index := up[int(h.next.Add(1)%uint64(len(up)))]
+	process := pm3.process(index)
+	port, err := process.proxyPort()
+	if err != nil {
+		writeProxyPage(w, http.StatusBadGateway, "Bad gateway", err.Error(), false)
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+			pr.Out.Host = pr.In.Host
+			pr.SetXForwarded()
+		},
+		// The process may be up without listening yet, or just going down.
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			writeProxyPage(w, http.StatusBadGateway, process.cfg.Name+" is restarting",
+				fmt.Sprintf("%s isn't accepting connections yet, this page will reload when it's back.", process.cfg.Name), true)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+func proxyStateText(state ProcessState) string {
+	switch state {
+	case StateStarting, StateRunning:
+		return "starting"
+	case StateStopping, StateBackoff, StateCrashed:
+		return "restarting"
+	case StateCrashLoop:
+		return "crash looping"
+	}
+	return state.String()
+}
+
+func writeProxyPage(w http.ResponseWriter, status int, title, message string, reload bool) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	refresh := ""
+	if reload {
+		refresh = `<meta http-equiv="refresh" content="1">`
+	}
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head><title>%s</title>%s</head>
+<body style="font-family: sans-serif; margin: 4em; color: #333">
+<h2>%s</h2>
+<p>%s</p>
+<p style="color: #999">gopm3</p>
+</body>
+</html>
+`, html.EscapeString(title), refresh, html.EscapeString(title), html.EscapeString(message))
+}
+
+// serveProxy runs the reverse proxy until shutdown, if any process is
+// proxied.
+func (pm3 *ProcessManager) serveProxy() {
+	enabled := false
+	for _, process := range pm3.snapshotProcesses() {
+		enabled = enabled || process.cfg.Proxy != nil
+	}
+	if !enabled {
+		return
+	}
+
+	listen := pm3.settings.ProxyListen
+	if listen == "" {
+		listen = defaultProxyListen
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		pm3.Log("Could not start the proxy: %v\n", err)
+		return
+	}
+	server := &http.Server{
+		Handler:           &proxyHandler{pm3: pm3},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-pm3.done
+		server.Close()
+	}()
+
+	pm3.Log("Proxying <name>.localhost on %s\n", listener.Addr())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		pm3.Log("Proxy stopped: %v\n", err)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProxyRoutes(t *testing.T) {
+	pm3 := &ProcessManager{processes: []*Process{
+		{cfg: ProcessConfig{Name: "web"}},
+		{cfg: ProcessConfig{Name: "api-1", Proxy: &ProxyConfig{}}, replicaOf: "api", instance: 1},
+		{cfg: ProcessConfig{Name: "api-2", Proxy: &ProxyConfig{}}, replicaOf: "api", instance: 2},
+		{cfg: ProcessConfig{Name: "docs", Proxy: &ProxyConfig{Host: "Docs.test"}}},
+	}}
+
+	tests := []struct {
+		host string
+		want []int
+	}{
+		{host: "api.localhost", want: []int{1, 2}},
+		{host: "api-2.localhost", want: []int{2}},
+		{host: "docs.test", want: []int{3}},
+		{host: "docs.localhost", want: nil},
+		{host: "web.localhost", want: nil},
+	}
+	for _, tt := range tests {
+		if got := pm3.proxyRoutes(tt.host); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("proxyRoutes(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * foo *",
+		"@every",
+		"@every -5m",
+		"@fortnightly",
+		"0 0 30 2 *",
+		"0 0 31 4,6,9,11 *",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// A Wednesday.
+	from := time.Date(2025, time.January, 1, 10, 30, 15, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{spec: "@every 90s", want: from.Add(90 * time.Second)},
+		{spec: "* * * * *", want: time.Date(2025, 1, 1, 10, 31, 0, 0, time.UTC)},
+		{spec: "45 * * * *", want: time.Date(2025, 1, 1, 10, 45, 0, 0, time.UTC)},
+		{spec: "15 * * * *", want: time.Date(2025, 1, 1, 11, 15, 0, 0, time.UTC)},
+		{spec: "0 9-17 * * *", want: time.Date(2025, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{spec: "0 20-23 * * *", want: time.Date(2025, 1, 1, 20, 0, 0, 0, time.UTC)},
+		{spec: "*/20 * * * *", want: time.Date(2025, 1, 1, 10, 40, 0, 0, time.UTC)},
+		{spec: "10-50/15 * * * *", want: time.Date(2025, 1, 1, 10, 40, 0, 0, time.UTC)},
+		{spec: "5/25 * * * *", want: time.Date(2025, 1, 1, 10, 55, 0, 0, time.UTC)},
+		{spec: "0,31 * * * *", want: time.Date(2025, 1, 1, 10, 31, 0, 0, time.UTC)},
+		{spec: "0 0 1 mar *", want: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 * * FRI", want: time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 * * mon-tue", want: time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 * * 7", want: time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 29 2 *", want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{spec: "@hourly", want: time.Date(2025, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{spec: "@daily", want: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{spec: "@weekly", want: time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{spec: "@monthly", want: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "@yearly", want: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// Day of month and day of week both restricted: either one matches.
+		{spec: "0 0 15 * fri", want: time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 2 * sun", want: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)},
+		// Only one restricted: it alone decides.
+		{spec: "0 0 15 * *", want: time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 ? * sun", want: time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", from, got, tt.want)
+			}
+		})
+	}
+}
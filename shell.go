@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var defaultShell = []string{"/bin/sh", "-c"}
+
+// ShellConfig runs the process's command through a shell. In the config it is
+// either `"shell": true` for /bin/sh, or the shell to use such as "bash -lc",
+// "zsh" or "$SHELL". -c is added unless the flags already end with it.
+type ShellConfig struct {
+	argv []string
+	raw  json.RawMessage
+}
+
+func (s *ShellConfig) UnmarshalJSON(data []byte) error {
+	var enabled bool
+	if err := json.Unmarshal(data, &enabled); err == nil {
+		s.argv = nil
+		if enabled {
+			s.argv = defaultShell
+		}
+		s.raw = data
+		return nil
+	}
+
+	var shell string
+	if err := json.Unmarshal(data, &shell); err != nil {
+		return fmt.Errorf("shell: expected true, false or a shell command: %w", err)
+	}
+	argv := strings.Fields(shell)
+	if len(argv) == 0 {
+		return fmt.Errorf("shell: empty shell command")
+	}
+	argv[0] = os.ExpandEnv(argv[0])
+	if argv[0] == "" {
+		return fmt.Errorf("shell: %q expands to nothing", shell)
+	}
+	if !endsWithCommandFlag(argv[1:]) {
+		argv = append(argv, "-c")
+	}
+	s.argv = argv
+	s.raw = data
+	return nil
+}
+
+// endsWithCommandFlag reports whether the last flag is -c, alone or grouped
+// like -lc.
+func endsWithCommandFlag(flags []string) bool {
+	if len(flags) == 0 {
+		return false
+	}
+	last := flags[len(flags)-1]
+	return strings.HasPrefix(last, "-") && !strings.HasPrefix(last, "--") && strings.HasSuffix(last, "c")
+}
+
+func (s ShellConfig) MarshalJSON() ([]byte, error) {
+	if s.raw == nil {
+		return []byte("false"), nil
+	}
+	return s.raw, nil
+}
+
+func (s *ShellConfig) enabled() bool {
+	return s != nil && len(s.argv) > 0
+}
+
+// shellQuote quotes s for a POSIX shell, leaving simple words alone.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+./:,@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// wrap returns the command and args that run command through the shell. The
+// command is a shell script, args are appended to it quoted so they're passed
+// through literally.
+func (s *ShellConfig) wrap(command string, args []string) (string, []string) {
+	script := command
+	for _, arg := range args {
+		script += " " + shellQuote(arg)
+	}
+	wrapped := append(append([]string(nil), s.argv[1:]...), script)
+	return s.argv[0], wrapped
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestShellConfigUnmarshal(t *testing.T) {
+	tests := []struct {
+		config string
+		want   []string
+	}{
+		{config: `true`, want: []string{"/bin/sh", "-c"}},
+		{config: `false`, want: nil},
+		{config: `"zsh"`, want: []string{"zsh", "-c"}},
+		{config: `"bash -c"`, want: []string{"bash", "-c"}},
+		{config: `"bash -lc"`, want: []string{"bash", "-lc"}},
+		{config: `"bash -l"`, want: []string{"bash", "-l", "-c"}},
+		{config: `"bash --norc"`, want: []string{"bash", "--norc", "-c"}},
+	}
+	for _, tt := range tests {
+		var shell ShellConfig
+		if err := json.Unmarshal([]byte(tt.config), &shell); err != nil {
+			t.Fatalf("%s: %v", tt.config, err)
+		}
+		if !reflect.DeepEqual(shell.argv, tt.want) {
+			t.Errorf("%s: argv = %q, want %q", tt.config, shell.argv, tt.want)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "plain", want: "plain"},
+		{in: "--port=8080", want: "--port=8080"},
+		{in: "/tmp/a.log", want: "/tmp/a.log"},
+		{in: "", want: "''"},
+		{in: "two words", want: "'two words'"},
+		{in: "$HOME", want: "'$HOME'"},
+		{in: "it's", want: `'it'\''s'`},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestShellWrap(t *testing.T) {
+	shell := &ShellConfig{argv: []string{"bash", "-l", "-c"}}
+	command, args := shell.wrap("echo $PATH | tr : '\\n'", []string{"a b", "c"})
+	if command != "bash" {
+		t.Errorf("command = %q, want bash", command)
+	}
+	want := []string{"-l", "-c", `echo $PATH | tr : '\n' 'a b' c`}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %q, want %q", args, want)
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// ProcessState is the lifecycle state of a managed process. Each process has a
+// supervisor goroutine that owns its state and moves it between states in
+// response to processEvents.
+type ProcessState int
+
+const (
+	StateStopped ProcessState = iota
+	StateStarting
+	StateRunning
+	StateReady
+	StateStopping
+	StateBackoff
+	StateCrashed
+	StateExited
+	StateFailed
+	StateCrashLoop
+)
+
+var processStateNames = [...]string{
+	StateStopped:   "stopped",
+	StateStarting:  "starting",
+	StateRunning:   "running",
+	StateReady:     "ready",
+	StateStopping:  "stopping",
+	StateBackoff:   "backoff",
+	StateCrashed:   "crashed",
+	StateExited:    "exited",
+	StateFailed:    "failed",
+	StateCrashLoop: "crash loop",
+}
+
+func (s ProcessState) String() string {
+	if int(s) < len(processStateNames) {
+		return processStateNames[s]
+	}
+	return fmt.Sprintf("ProcessState(%d)", int(s))
+}
+
+// active reports whether a run of the process is in flight.
+func (s ProcessState) active() bool {
+	switch s {
+	case StateStarting, StateRunning, StateReady, StateStopping:
+		return true
+	}
+	return false
+}
+
+type processEventKind int
+
+const (
+	// Requests, from the TUI, watchers, scaling, etc.
+	eventStart processEventKind = iota
+	eventStop
+	eventRestart
+
+	// Progress reports from the goroutine running the command.
+	eventStarted
+	eventReady
+	eventExited
+	eventStartFailed
+)
+
+type processEvent struct {
+	kind processEventKind
+	err  error
+}
+
+// supervisor holds the per-process bookkeeping that only the supervising
+// goroutine touches.
+type supervisor struct {
+	pm3     *ProcessManager
+	process *Process
+	index   int
+
+	// Start again once the current run has exited (restart, or a queued or
+	// killed scheduled run).
+	startAfterStop bool
+	// A scheduled run that's waiting for the current run to finish.
+	runQueued bool
+
+	backoff  *time.Timer
+	schedule *time.Timer
+
+	// Recent exits that were followed by a restart, for crash loop detection.
+	exits []time.Time
+}
+
+func timerC(t *time.Timer) <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+	return t.C
+}
+
+func (pm3 *ProcessManager) processState(index int) ProcessState {
+	pm3.mu.Lock()
+	defer pm3.mu.Unlock()
+	return pm3.processes[index].state
+}
+
+func (pm3 *ProcessManager) setState(index int, state ProcessState) {
+	pm3.mu.Lock()
+	pm3.processes[index].state = state
+	pm3.mu.Unlock()
+	pm3.render(index)
+}
+
+// render updates the process list label from the process state.
+func (pm3 *ProcessManager) render(index int) {
+	pm3.mu.Lock()
+	process := pm3.processes[index]
+	state := process.state
+	nextRun := process.nextRun
+	pm3.mu.Unlock()
+
+	name := process.cfg.Name
+	var label string
+	switch {
+	case state == StateFailed:
+		label = fmt.Sprintf("[red](failed)[white] %s", name)
+	case state == StateCrashLoop:
+		label = fmt.Sprintf("[red](crash loop)[white] %s", name)
+	case !state.active() && state != StateBackoff && !nextRun.IsZero():
+		label = fmt.Sprintf("[blue](next %s)[white] %s", nextRun.Format("15:04:05"), name)
+	case state == StateStopped && pm3.isShuttingDown():
+		label = fmt.Sprintf("[red](dead)[white] %s", name)
+	case state == StateStopped:
+		label = fmt.Sprintf("[gray](stopped)[white] %s", name)
+	case state == StateStarting:
+		label = fmt.Sprintf("[yellow](starting)[white] %s", name)
+	case state == StateRunning:
+		label = fmt.Sprintf("[yellow](running)[white] %s", name)
+	case state == StateStopping:
+		label = fmt.Sprintf("[yellow](stopping)[white] %s", name)
+	case state == StateBackoff:
+		label = fmt.Sprintf("[yellow](restarting)[white] %s", name)
+	case state == StateCrashed:
+		label = fmt.Sprintf("[red](crashed)[white] %s", name)
+	case state == StateExited:
+		label = fmt.Sprintf("[gray](exited)[white] %s", name)
+	default:
+		label = name
+	}
+	pm3.tuiProcessList.SetItemText(index, label, "")
+}
+
+// post delivers an event to the process supervisor. Events sent after the
+// supervisor has finished are dropped.
+func (pm3 *ProcessManager) post(index int, kind processEventKind, err error) {
+	process := pm3.process(index)
+	select {
+	case process.events <- processEvent{kind: kind, err: err}:
+	case <-process.supervisorDone:
+	}
+}
+
+// supervise runs the state machine for a single process until shutdown.
+func (pm3 *ProcessManager) supervise(process *Process, index int) {
+	defer pm3.wg.Done()
+	defer close(process.supervisorDone)
+
+	s := &supervisor{pm3: pm3, process: process, index: index}
+	done := pm3.done
+	pm3.render(index)
+	for {
+		if done == nil && !pm3.processState(index).active() {
+			pm3.setState(index, StateStopped)
+			return
+		}
+
+		select {
+		case <-done:
+			done = nil
+			s.shutdown()
+		case <-timerC(s.backoff):
+			s.backoff = nil
+			if pm3.processState(index) == StateBackoff {
+				pm3.Log("Restarting process '%s'\n", process.cfg.Name)
+				pm3.notify(index, NotifyRestart, "restarting after it exited")
+				s.writeRestartBanner()
+				s.start()
+			}
+		case <-timerC(s.schedule):
+			s.scheduleTick()
+		case event := <-process.events:
+			s.handle(event)
+		}
+	}
+}
+
+func (s *supervisor) state() ProcessState {
+	return s.pm3.processState(s.index)
+}
+
+func (s *supervisor) start() {
+	s.cancelBackoff()
+	s.pm3.setState(s.index, StateStarting)
+	go s.pm3.execProcess(s.process, s.index)
+}
+
+func (s *supervisor) stop() {
+	s.pm3.setState(s.index, StateStopping)
+	go s.pm3.StopProcess(s.index)
+}
+
+func (s *supervisor) cancelBackoff() {
+	if s.backoff != nil {
+		s.backoff.Stop()
+		s.backoff = nil
+	}
+}
+
+func (s *supervisor) armSchedule() {
+	if s.process.schedule == nil {
+		return
+	}
+	next := s.process.schedule.Next(time.Now())
+	if next.IsZero() {
+		s.pm3.Log("Process '%s' has no upcoming run for its schedule\n", s.process.cfg.Name)
+		s.cancelSchedule()
+		return
+	}
+	if s.schedule != nil {
+		s.schedule.Stop()
+	}
+	s.schedule = time.NewTimer(time.Until(next))
+
+	s.pm3.mu.Lock()
+	s.process.nextRun = next
+	s.pm3.mu.Unlock()
+	s.pm3.render(s.index)
+}
+
+func (s *supervisor) cancelSchedule() {
+	if s.schedule != nil {
+		s.schedule.Stop()
+		s.schedule = nil
+	}
+	s.runQueued = false
+
+	s.pm3.mu.Lock()
+	s.process.nextRun = time.Time{}
+	s.pm3.mu.Unlock()
+}
+
+func (s *supervisor) shutdown() {
+	s.cancelBackoff()
+	s.cancelSchedule()
+	s.startAfterStop = false
+
+	switch s.state() {
+	case StateStarting, StateRunning, StateReady:
+		// The global Stop takes care of signalling every running command.
+		s.pm3.setState(s.index, StateStopping)
+	case StateStopping:
+	default:
+		s.pm3.setState(s.index, StateStopped)
+	}
+}
+
+func (s *supervisor) handle(event processEvent) {
+	pm3 := s.pm3
+	name := s.process.cfg.Name
+	state := s.state()
+	shuttingDown := pm3.isShuttingDown()
+
+	switch event.kind {
+	case eventStart:
+		if shuttingDown {
+			return
+		}
+		s.exits = nil
+		switch {
+		case state == StateStopping:
+			s.startAfterStop = true
+		case state == StateBackoff:
+			s.start()
+		case state.active():
+		case s.process.schedule != nil:
+			// Starting a scheduled process enables its schedule, runs happen on ticks.
+			if s.schedule == nil {
+				pm3.Log("Scheduling process '%s'\n", name)
+				s.armSchedule()
+			}
+		default:
+			pm3.Log("Starting process '%s'\n", name)
+			s.start()
+		}
+
+	case eventStop:
+		s.startAfterStop = false
+		s.cancelSchedule()
+		switch {
+		case state == StateStopping:
+		case state.active():
+			pm3.Log("Stopping process '%s'\n", name)
+			s.stop()
+		default:
+			s.cancelBackoff()
+			pm3.setState(s.index, StateStopped)
+		}
+
+	case eventRestart:
+		if shuttingDown {
+			return
+		}
+		s.exits = nil
+		pm3.Log("Restarting process '%s'\n", name)
+		switch {
+		case state == StateStopping:
+			s.startAfterStop = true
+		case state.active():
+			s.startAfterStop = true
+			s.stop()
+		default:
+			s.start()
+		}
+
+	case eventStarted:
+		switch state {
+		case StateStarting:
+			pm3.setState(s.index, StateRunning)
+		case StateStopping:
+			// A stop was requested before the command existed, signal it now.
+			if !shuttingDown {
+				go pm3.StopProcess(s.index)
+			}
+		}
+
+	case eventReady:
+		if state == StateRunning {
+			pm3.setState(s.index, StateReady)
+			pm3.notify(s.index, NotifyReady, "ready")
+		}
+
+	case eventStartFailed:
+		if state == StateStopping {
+			s.exited(nil)
+			return
+		}
+		// Don't loop on a broken pre_start hook, wait for a manual start instead.
+		pm3.Log("Process '%s' was not started: %v\n", name, event.err)
+		pm3.setState(s.index, StateFailed)
+		if s.process.cfg.ExitOnComplete || s.process.cfg.Required {
+			pm3.complete(s.index, 1)
+		}
+
+	case eventExited:
+		s.exited(event.err)
+	}
+}
+
+// exited moves the process out of an active state once its command is gone.
+func (s *supervisor) exited(err error) {
+	pm3 := s.pm3
+	shuttingDown := pm3.isShuttingDown()
+
+	if s.state() == StateStopping || shuttingDown {
+		if s.startAfterStop && !shuttingDown {
+			s.startAfterStop = false
+			s.writeRestartBanner()
+			s.start()
+			return
+		}
+		pm3.setState(s.index, StateStopped)
+		return
+	}
+
+	if err != nil {
+		pm3.setState(s.index, StateCrashed)
+		pm3.notify(s.index, NotifyCrash, fmt.Sprintf("crashed: %v", err))
+	} else {
+		pm3.setState(s.index, StateExited)
+	}
+
+	// Completion of an exit_on_complete process, or a required one crashing,
+	// ends the whole session.
+	if s.process.cfg.ExitOnComplete || (s.process.cfg.Required && err != nil) {
+		pm3.complete(s.index, exitCodeOf(err))
+		return
+	}
+
+	// Scheduled processes wait for their next tick rather than restarting.
+	if s.process.schedule != nil {
+		if s.runQueued {
+			s.runQueued = false
+			s.start()
+		}
+		return
+	}
+
+	if s.crashLooping() {
+		pm3.setState(s.index, StateCrashLoop)
+		pm3.reportCrashLoop(s.index, len(s.exits))
+		pm3.notify(s.index, NotifyCrashLoop, fmt.Sprintf("exited %d times within %s", len(s.exits), pm3.settings.crashLoopWindow()))
+		return
+	}
+
+	pm3.setState(s.index, StateBackoff)
+	s.backoff = time.NewTimer(time.Duration(s.process.cfg.RestartDelay) * time.Millisecond)
+}
+
+func (s *supervisor) writeRestartBanner() {
+	textView := s.process.textView
+	textView.Write([]byte("====================================================\n"))
+	textView.Write([]byte("==================== Restarting ====================\n"))
+	textView.Write([]byte("====================================================\n"))
+}
+
+func (s *supervisor) scheduleTick() {
+	pm3 := s.pm3
+	name := s.process.cfg.Name
+	s.schedule = nil
+	s.armSchedule()
+
+	state := s.state()
+	if !state.active() {
+		s.start()
+		return
+	}
+
+	switch s.process.cfg.Overlap {
+	case OverlapQueue:
+		pm3.Log("Previous run of '%s' still going, queueing scheduled run\n", name)
+		s.runQueued = true
+	case OverlapKill:
+		pm3.Log("Previous run of '%s' still going, stopping it for scheduled run\n", name)
+		s.startAfterStop = true
+		if state != StateStopping {
+			s.stop()
+		}
+	default:
+		pm3.Log("Previous run of '%s' still going, skipping scheduled run\n", name)
+	}
+}
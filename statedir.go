@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// StateDir holds the logs, pid and cid files and the lock file of this gopm3
+// session. It is resolved once at startup, before any process is created.
+var StateDir string
+
+// defaultStateDir namespaces the state of each project under ~/.gopm3, so
+// projects with processes of the same name don't clobber each other.
+func defaultStateDir(cfgPath string) string {
+	abs, err := filepath.Abs(cfgPath)
+	if err != nil {
+		abs = cfgPath
+	}
+	sum := sha256.Sum256([]byte(abs))
+	project := sanitizeProcessName(filepath.Base(filepath.Dir(abs)))
+	return filepath.Join(os.Getenv("HOME"), ".gopm3", fmt.Sprintf("%s-%x", project, sum[:4]))
+}
+
+// resolveStateDir picks the state dir from the --state-dir flag, then
+// $GOPM3_STATE_DIR, then the state_dir setting, then the per-project default.
+func resolveStateDir(flagValue, settingValue, cfgPath string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if dir := os.Getenv("GOPM3_STATE_DIR"); dir != "" {
+		return dir
+	}
+	if settingValue != "" {
+		// Relative to the config file, so it doesn't depend on where gopm3 runs.
+		if !filepath.IsAbs(settingValue) {
+			return filepath.Join(filepath.Dir(cfgPath), settingValue)
+		}
+		return settingValue
+	}
+	return defaultStateDir(cfgPath)
+}
+
+// stateDirID is a short id of the state dir, which namespaces the docker
+// containers of each project.
+func stateDirID() string {
+	abs, err := filepath.Abs(StateDir)
+	if err != nil {
+		abs = StateDir
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return fmt.Sprintf("%x", sum[:4])
+}
+
+func statePath(name string) string {
+	return filepath.Join(StateDir, name)
+}
+
+// displayStateDir shortens the state dir for the TUI.
+func displayStateDir() string {
+	if home := os.Getenv("HOME"); home != "" {
+		if rel, ok := strings.CutPrefix(StateDir, home+"/"); ok {
+			return "~/" + rel
+		}
+	}
+	return StateDir
+}
+
+// lockStateDir creates the state dir and takes an exclusive lock on it, so two
+// gopm3 instances never run the same project. The lock is held until the
+// returned file is closed or gopm3 exits.
+func lockStateDir(dir string) (*os.File, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	lockPath := filepath.Join(dir, "gopm3.lock")
+	lockFile, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		owner, _ := os.ReadFile(lockPath)
+		lockFile.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("gopm3 (pid %s) is already running with state dir %s", strings.TrimSpace(string(owner)), dir)
+		}
+		return nil, err
+	}
+	_ = lockFile.Truncate(0)
+	_, _ = lockFile.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return lockFile, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var errSubreaperUnsupported = errors.New("child subreaper isn't available")
+
+// How often the remaining adopted processes are checked while waiting for them
+// to exit at shutdown.
+const adoptedPollInterval = 100 * time.Millisecond
+
+// childRegistry keeps the pids of the commands gopm3 started itself, which
+// are reaped by their exec.Cmd. Any other child of gopm3 has been adopted as
+// a subreaper and is reaped by reapAdopted.
+type childRegistry struct {
+	mu   sync.Mutex
+	pids map[int]bool
+}
+
+var children = &childRegistry{pids: make(map[int]bool)}
+
+// start starts cmd and registers it before the reaper can see it exit.
+func (r *childRegistry) start(cmd *exec.Cmd) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	r.pids[cmd.Process.Pid] = true
+	return nil
+}
+
+func (r *childRegistry) wait(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	r.mu.Lock()
+	delete(r.pids, cmd.Process.Pid)
+	r.mu.Unlock()
+	return err
+}
+
+func (r *childRegistry) run(cmd *exec.Cmd) error {
+	if err := r.start(cmd); err != nil {
+		return err
+	}
+	return r.wait(cmd)
+}
+
+// adopted returns the children of gopm3 it didn't start. r.mu must be held.
+func (r *childRegistry) adopted(table []procEntry) []procEntry {
+	self := os.Getpid()
+	var found []procEntry
+	for _, entry := range table {
+		if entry.ppid == self && !r.pids[entry.pid] {
+			found = append(found, entry)
+		}
+	}
+	return found
+}
+
+func (r *childRegistry) liveAdopted() []procEntry {
+	table, err := listProcesses()
+	if err != nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var live []procEntry
+	for _, entry := range r.adopted(table) {
+		if !entry.zombie() {
+			live = append(live, entry)
+		}
+	}
+	return live
+}
+
+// setupSubreaper makes gopm3 the parent of orphaned descendants of its
+// processes, instead of init, so they can't outlive the session.
+func (pm3 *ProcessManager) setupSubreaper() {
+	if err := setChildSubreaper(); err != nil {
+		if !errors.Is(err, errSubreaperUnsupported) {
+			pm3.Log("Could not become a child subreaper: %v\n", err)
+		}
+		return
+	}
+	go pm3.reapAdopted()
+}
+
+// reapAdopted reaps adopted processes as they exit. It runs until gopm3 exits,
+// since orphans are still adopted while shutting down.
+func (pm3 *ProcessManager) reapAdopted() {
+	sigchld := make(chan os.Signal, 1)
+	signal.Notify(sigchld, syscall.SIGCHLD)
+	for range sigchld {
+		pm3.reapZombies()
+	}
+}
+
+func (pm3 *ProcessManager) reapZombies() {
+	table, err := listProcesses()
+	if err != nil {
+		return
+	}
+	children.mu.Lock()
+	defer children.mu.Unlock()
+	for _, entry := range children.adopted(table) {
+		if !entry.zombie() {
+			continue
+		}
+		// Waiting on the pid alone leaves the commands' own exits to exec.Cmd.
+		var status syscall.WaitStatus
+		if pid, err := syscall.Wait4(entry.pid, &status, syscall.WNOHANG, nil); err != nil || pid != entry.pid {
+			continue
+		}
+		if index := pm3.originOf(entry); index >= 0 {
+			pm3.Log("Reaped %s orphaned by '%s' (%s)\n", entry, pm3.process(index).cfg.Name, waitStatusString(status))
+		} else {
+			pm3.Log("Reaped orphaned process %s (%s)\n", entry, waitStatusString(status))
+		}
+	}
+}
+
+func waitStatusString(status syscall.WaitStatus) string {
+	if status.Signaled() {
+		return "signal: " + status.Signal().String()
+	}
+	return fmt.Sprintf("exit status %d", status.ExitStatus())
+}
+
+// signalAdopted sends sig to every adopted process that's still running.
+func signalAdopted(sig syscall.Signal) {
+	for _, entry := range children.liveAdopted() {
+		_ = syscall.Kill(entry.pid, sig)
+	}
+}
+
+// stopAdopted waits out the grace period for the adopted processes still
+// running once every managed process has exited, then force-kills them.
+func (pm3 *ProcessManager) stopAdopted() {
+	deadline := time.Now().Add(SigKillGracePeriod)
+	adopted := children.liveAdopted()
+	for len(adopted) > 0 && time.Now().Before(deadline) {
+		time.Sleep(adoptedPollInterval)
+		adopted = children.liveAdopted()
+	}
+	if len(adopted) == 0 {
+		return
+	}
+	names := make([]string, len(adopted))
+	for i, entry := range adopted {
+		_ = syscall.Kill(entry.pid, syscall.SIGKILL)
+		names[i] = entry.String()
+	}
+	pm3.Log("%d orphaned process(es) still running after %s, killed: %s\n", len(adopted), SigKillGracePeriod, strings.Join(names, ", "))
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+func setChildSubreaper() error {
+	return errSubreaperUnsupported
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How often the descendants of running processes are recorded.
+const descendantPollInterval = time.Second
+
+// procEntry is a process found in the process table. Its pid and start time
+// together identify it across pid reuse.
+type procEntry struct {
+	pid       int
+	ppid      int
+	startTime uint64
+	state     byte
+	comm      string
+}
+
+func (e procEntry) String() string {
+	return fmt.Sprintf("%d (%s)", e.pid, e.comm)
+}
+
+func (e procEntry) zombie() bool {
+	return e.state == 'Z'
+}
+
+// descendantsOf returns every process below the roots in the process table.
+func descendantsOf(table []procEntry, roots ...int) []procEntry {
+	children := make(map[int][]procEntry)
+	for _, entry := range table {
+		children[entry.ppid] = append(children[entry.ppid], entry)
+	}
+	var found []procEntry
+	seen := make(map[int]bool)
+	queue := append([]int(nil), roots...)
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		for _, child := range children[pid] {
+			if seen[child.pid] {
+				continue
+			}
+			seen[child.pid] = true
+			found = append(found, child)
+			queue = append(queue, child.pid)
+		}
+	}
+	return found
+}
+
+// trackDescendants keeps a record of every process each running command has
+// spawned. Descendants that detach with setsid() and get reparented once their
+// parent exits can then still be found when the process is stopped.
+func (pm3 *ProcessManager) trackDescendants() {
+	ticker := time.NewTicker(descendantPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pm3.done:
+			return
+		case <-ticker.C:
+		}
+		pm3.recordDescendants()
+	}
+}
+
+func (pm3 *ProcessManager) recordDescendants() {
+	table, err := listProcesses()
+	if err != nil {
+		return
+	}
+	for i, cmd := range pm3.snapshotRunningCmds() {
+		if cmd == nil || cmd.Process == nil {
+			pm3.process(i).recordTree(table)
+			continue
+		}
+		pm3.process(i).recordTree(table, cmd.Process.Pid)
+	}
+}
+
+// recordTree forgets the recorded descendants that are gone and adds
+// everything below the roots and the ones still running. Descendants adopted
+// by gopm3 keep being followed that way after their parent has exited.
+func (process *Process) recordTree(table []procEntry, roots ...int) {
+	alive := make(map[int]uint64, len(table))
+	for _, entry := range table {
+		alive[entry.pid] = entry.startTime
+	}
+
+	process.treeMu.Lock()
+	defer process.treeMu.Unlock()
+	if process.tree == nil {
+		process.tree = make(map[int]procEntry)
+	}
+	for pid, entry := range process.tree {
+		if startTime, ok := alive[pid]; !ok || startTime != entry.startTime {
+			delete(process.tree, pid)
+		}
+	}
+	for pid := range process.tree {
+		roots = append(roots, pid)
+	}
+	for _, entry := range descendantsOf(table, roots...) {
+		process.tree[entry.pid] = entry
+	}
+}
+
+// originOf returns the index of the process that entry was recorded as a
+// descendant of, or -1.
+func (pm3 *ProcessManager) originOf(entry procEntry) int {
+	for i := range pm3.snapshotRunningCmds() {
+		process := pm3.process(i)
+		process.treeMu.Lock()
+		recorded, ok := process.tree[entry.pid]
+		process.treeMu.Unlock()
+		if ok && recorded.startTime == entry.startTime {
+			return i
+		}
+	}
+	return -1
+}
+
+// liveDescendants returns the recorded descendants that are still running.
+func (process *Process) liveDescendants() []procEntry {
+	table, err := listProcesses()
+	if err != nil {
+		return nil
+	}
+	process.recordTree(table)
+
+	process.treeMu.Lock()
+	defer process.treeMu.Unlock()
+	entries := make([]procEntry, 0, len(process.tree))
+	for _, entry := range process.tree {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// descendants returns the live descendants of the process, both the current
+// ones and those recorded earlier.
+func (pm3 *ProcessManager) descendants(index int) []procEntry {
+	if cmd := pm3.getRunningCmd(index); cmd != nil && cmd.Process != nil {
+		if table, err := listProcesses(); err == nil {
+			pm3.process(index).recordTree(table, cmd.Process.Pid)
+		}
+	}
+	return pm3.process(index).liveDescendants()
+}
+
+// signalDescendants sends sig to every descendant of the process and returns
+// them.
+func (pm3 *ProcessManager) signalDescendants(index int, sig syscall.Signal) []procEntry {
+	descendants := pm3.descendants(index)
+	for _, entry := range descendants {
+		_ = syscall.Kill(entry.pid, sig)
+	}
+	return descendants
+}
+
+// killSurvivors force-kills the given descendants that are still running once
+// the grace period is over, and reports them.
+func (pm3 *ProcessManager) killSurvivors(index int, descendants []procEntry) {
+	table, err := listProcesses()
+	if err != nil {
+		return
+	}
+	alive := make(map[int]procEntry, len(table))
+	for _, entry := range table {
+		alive[entry.pid] = entry
+	}
+	var survivors []procEntry
+	for _, entry := range descendants {
+		if current, ok := alive[entry.pid]; ok && current.startTime == entry.startTime && !current.zombie() {
+			_ = syscall.Kill(entry.pid, syscall.SIGKILL)
+			survivors = append(survivors, entry)
+		}
+	}
+	if len(survivors) == 0 {
+		return
+	}
+	names := make([]string, len(survivors))
+	for i, entry := range survivors {
+		names[i] = entry.String()
+	}
+	pm3.Log("Process '%s' left %d descendant(s) running after %s, killed: %s\n",
+		pm3.process(index).cfg.Name, len(survivors), SigKillGracePeriod, strings.Join(names, ", "))
+}
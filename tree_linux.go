@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readProcStat reads the entry of pid from /proc/<pid>/stat.
+func readProcStat(pid int) (procEntry, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return procEntry{}, err
+	}
+	// The command name may contain spaces and parens, split at its last ')'.
+	open := bytes.IndexByte(stat, '(')
+	end := bytes.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return procEntry{}, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	// Fields after the command name start at field 3 (state), starttime is 22.
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 20 {
+		return procEntry{}, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	startTime, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return procEntry{}, fmt.Errorf("malformed /proc/%d/stat: %w", pid, err)
+	}
+	return procEntry{
+		pid:       pid,
+		ppid:      ppid,
+		startTime: startTime,
+		state:     fields[0][0],
+		comm:      string(stat[open+1 : end]),
+	}, nil
+}
+
+// listProcesses reads the process table from /proc.
+func listProcesses() ([]procEntry, error) {
+	dir, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	table := make([]procEntry, 0, len(dir))
+	for _, d := range dir {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil {
+			continue
+		}
+		entry, err := readProcStat(pid)
+		if err != nil {
+			continue
+		}
+		table = append(table, entry)
+	}
+	return table, nil
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+func listProcesses() ([]procEntry, error) {
+	return nil, errProcessCheckUnsupported
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultWatchDebounce = 500 * time.Millisecond
+
+// Directories that are never worth watching unless explicitly included.
+var defaultWatchExcludes = []string{".git/**", "node_modules/**"}
+
+type WatchConfig struct {
+	Paths    []string `json:"paths,omitempty"`
+	Include  []string `json:"include,omitempty"`
+	Exclude  []string `json:"exclude,omitempty"`
+	Debounce int      `json:"debounce,omitempty"`
+	Command  string   `json:"command,omitempty"`
+}
+
+// fileWatcher emits the paths of files that were created, modified, removed or
+// renamed somewhere below its roots.
+type fileWatcher interface {
+	Events() <-chan string
+	Close() error
+}
+
+func (w *WatchConfig) roots() []string {
+	if len(w.Paths) == 0 {
+		return []string{"."}
+	}
+	return w.Paths
+}
+
+func (w *WatchConfig) debounce() time.Duration {
+	if w.Debounce > 0 {
+		return time.Duration(w.Debounce) * time.Millisecond
+	}
+	return defaultWatchDebounce
+}
+
+func (w *WatchConfig) excludes() []string {
+	return append(append([]string{}, defaultWatchExcludes...), w.Exclude...)
+}
+
+// matches reports whether a path (relative to its watch root) should trigger.
+func (w *WatchConfig) matches(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range w.excludes() {
+		if matchGlob(pattern, rel) {
+			return false
+		}
+	}
+	if len(w.Include) == 0 {
+		return true
+	}
+	for _, pattern := range w.Include {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipDir reports whether a directory can be left unwatched entirely.
+func (w *WatchConfig) skipDir(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range w.excludes() {
+		if dir, ok := strings.CutSuffix(pattern, "/**"); ok && matchGlob(dir, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches slash separated paths against a glob pattern where `**`
+// spans any number of directories. Patterns without a slash match the base name.
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// relativeToRoots returns the path relative to whichever root contains it.
+func relativeToRoots(roots []string, name string) string {
+	for _, root := range roots {
+		if rel, err := filepath.Rel(root, name); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return name
+}
+
+// walkDirs calls fn for every directory below start that isn't skipped.
+func walkDirs(start string, skipDir func(string) bool, fn func(string)) {
+	filepath.WalkDir(start, func(p string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if p != start && skipDir(p) {
+			return filepath.SkipDir
+		}
+		fn(p)
+		return nil
+	})
+}
+
+// dirSkipper adapts a root-relative skipDir check to full paths.
+func dirSkipper(roots []string, skipDir func(string) bool) func(string) bool {
+	return func(p string) bool {
+		return skipDir(relativeToRoots(roots, p))
+	}
+}
+
+// watchProcess restarts the process (or runs watch.command) whenever matching
+// files change, coalescing bursts of changes into a single trigger.
+func (pm3 *ProcessManager) watchProcess(process *Process, index int) {
+	cfg := process.cfg.Watch
+	roots := cfg.roots()
+	watcher, err := newFileWatcher(roots, cfg.skipDir)
+	if err != nil {
+		pm3.Log("Could not watch files for '%s': %v\n", process.cfg.Name, err)
+		return
+	}
+	defer watcher.Close()
+
+	debounce := time.NewTimer(cfg.debounce())
+	debounce.Stop()
+	var changed string
+	for {
+		select {
+		case <-pm3.done:
+			debounce.Stop()
+			return
+		case name, ok := <-watcher.Events():
+			if !ok {
+				return
+			}
+			rel := relativeToRoots(roots, name)
+			if !cfg.matches(rel) {
+				continue
+			}
+			changed = rel
+			debounce.Reset(cfg.debounce())
+		case <-debounce.C:
+			if pm3.isShuttingDown() {
+				return
+			}
+			// Leave stopped processes alone until they're started again.
+			if state := pm3.processState(index); state == StateStopped || state == StateFailed {
+				continue
+			}
+			if cfg.Command != "" {
+				pm3.Log("'%s' changed, running watch command for '%s'\n", changed, process.cfg.Name)
+				pm3.runHookCommand(process, "watch", cfg.Command)
+				continue
+			}
+			pm3.Log("'%s' changed, restarting process '%s'\n", changed, process.cfg.Name)
+			pm3.RestartProcess(index)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+type inotifyWatcher struct {
+	file    *os.File
+	fd      int
+	skipDir func(string) bool
+	events  chan string
+
+	mu    sync.Mutex
+	paths map[int]string
+}
+
+func newFileWatcher(roots []string, skipDir func(string) bool) (fileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	w := &inotifyWatcher{
+		// A non-blocking fd lets the runtime poller unblock Read on Close.
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		skipDir: dirSkipper(roots, skipDir),
+		events:  make(chan string, 64),
+		paths:   make(map[int]string),
+	}
+	for _, root := range roots {
+		walkDirs(root, w.skipDir, w.addDir)
+	}
+
+	go w.readLoop()
+	return w, nil
+}
+
+func (w *inotifyWatcher) addDir(dir string) {
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if err != nil {
+		return
+	}
+	w.mu.Lock()
+	w.paths[wd] = dir
+	w.mu.Unlock()
+}
+
+func (w *inotifyWatcher) readLoop() {
+	defer close(w.events)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			w.mu.Lock()
+			dir, ok := w.paths[int(event.Wd)]
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.paths, int(event.Wd))
+			}
+			w.mu.Unlock()
+			if !ok {
+				continue
+			}
+
+			name := filepath.Join(dir, string(bytes.TrimRight(nameBytes, "\x00")))
+			// Start watching new directories, including anything created inside
+			// them before the watch was in place.
+			if event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				walkDirs(name, w.skipDir, w.addDir)
+				continue
+			}
+			if event.Mask&syscall.IN_ISDIR != 0 || event.Mask&syscall.IN_DELETE_SELF != 0 {
+				continue
+			}
+
+			select {
+			case w.events <- name:
+			default:
+				// Dropping is fine, the debounce only needs one event per burst.
+			}
+		}
+	}
+}
+
+func (w *inotifyWatcher) Events() <-chan string {
+	return w.events
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,98 @@
+//go:build !linux
+
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+const watchPollInterval = time.Second
+
+// pollingWatcher is the fallback for platforms without inotify. It rescans the
+// tree periodically and reports files whose modification time changed.
+type pollingWatcher struct {
+	roots   []string
+	skipDir func(string) bool
+	events  chan string
+	done    chan struct{}
+}
+
+func newFileWatcher(roots []string, skipDir func(string) bool) (fileWatcher, error) {
+	w := &pollingWatcher{
+		roots:   roots,
+		skipDir: dirSkipper(roots, skipDir),
+		events:  make(chan string, 64),
+		done:    make(chan struct{}),
+	}
+	go w.pollLoop()
+	return w, nil
+}
+
+func (w *pollingWatcher) scan() map[string]time.Time {
+	files := make(map[string]time.Time)
+	for _, root := range w.roots {
+		filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if p != root && w.skipDir(p) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				files[p] = info.ModTime()
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+func (w *pollingWatcher) pollLoop() {
+	defer close(w.events)
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	previous := w.scan()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		current := w.scan()
+		for name, modTime := range current {
+			if before, ok := previous[name]; !ok || !before.Equal(modTime) {
+				w.emit(name)
+			}
+		}
+		for name := range previous {
+			if _, ok := current[name]; !ok {
+				w.emit(name)
+			}
+		}
+		previous = current
+	}
+}
+
+func (w *pollingWatcher) emit(name string) {
+	select {
+	case w.events <- name:
+	default:
+	}
+}
+
+func (w *pollingWatcher) Events() <-chan string {
+	return w.events
+}
+
+func (w *pollingWatcher) Close() error {
+	close(w.done)
+	return nil
+}